@@ -0,0 +1,78 @@
+package etf
+
+// The Append functions append single terms, encoded as Write encodes
+// them with a zero Context, for code that encodes values itself, such
+// as the MarshalETF methods made by cmd/etfgen.
+//
+// Tuples, lists and maps are encoded as a head followed by their
+// elements, or for maps their keys and values. A list of one or more
+// elements must then end with AppendNil, which on its own appends the
+// empty list.
+
+// defaultContext is the Context the Append functions encode with. It
+// is never changed, so it may be shared.
+var defaultContext Context
+
+// AppendAtom appends atom to b.
+func AppendAtom(b []byte, atom Atom) ([]byte, error) {
+	return defaultContext.appendAtom(b, atom)
+}
+
+// AppendBool appends v to b, as the atom true or false.
+func AppendBool(b []byte, v bool) []byte {
+	b, _ = defaultContext.appendBool(b, v)
+	return b
+}
+
+// AppendInt appends x to b.
+func AppendInt(b []byte, x int64) []byte {
+	return defaultContext.appendInt(b, x)
+}
+
+// AppendUint appends x to b.
+func AppendUint(b []byte, x uint64) []byte {
+	return defaultContext.appendUint(b, x)
+}
+
+// AppendFloat appends f to b.
+func AppendFloat(b []byte, f float64) []byte {
+	return defaultContext.appendFloat(b, f)
+}
+
+// AppendString appends s to b in format f, as a struct field with the
+// matching etf tag option is encoded.
+func AppendString(b []byte, s string, f StringFormat) ([]byte, error) {
+	return defaultContext.appendStringAs(b, s, f)
+}
+
+// AppendBinary appends data to b as a binary.
+func AppendBinary(b []byte, data []byte) ([]byte, error) {
+	return defaultContext.appendBinary(b, data)
+}
+
+// AppendTupleHead appends the head of a tuple of n elements to b.
+func AppendTupleHead(b []byte, n int) []byte {
+	return appendTupleHead(b, n)
+}
+
+// AppendListHead appends the head of a list of n elements to b, n
+// being at least 1.
+func AppendListHead(b []byte, n int) []byte {
+	// $lLLLL
+	b = append(b, ettList)
+	return be.AppendUint32(b, uint32(n))
+}
+
+// AppendNil appends the empty list to b, which also ends a list.
+func AppendNil(b []byte) []byte {
+	// $j
+	return append(b, ettNil)
+}
+
+// AppendMapHead appends the head of a map of n pairs to b. The pairs
+// must follow sorted by key, in map key order.
+func AppendMapHead(b []byte, n int) []byte {
+	// $tAAAA
+	b = append(b, ettMap)
+	return be.AppendUint32(b, uint32(n))
+}
@@ -0,0 +1,106 @@
+package etf
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+const (
+	atomCacheSize = 2048
+	// a distribution header can refer to at most 255 cache entries
+	maxAtomCacheRefs = math.MaxUint8
+	// atoms are limited to 255 characters
+	maxAtomChars = 255
+)
+
+// outCache keeps track of the atoms a peer holds in its atom cache
+// for the outgoing side of a connection, and of the atoms the
+// current distribution message refers to.
+type outCache struct {
+	atoms [atomCacheSize]Atom
+	used  [atomCacheSize]bool
+
+	// cache references of the current message, by atom
+	refs map[Atom]uint8
+
+	// atoms seen while scanning the terms of a message, in order
+	scanning bool
+	seen     map[Atom]bool
+	found    []Atom
+}
+
+type outCacheEntry struct {
+	slot  uint16
+	atom  Atom
+	isNew bool
+}
+
+// note records an atom encountered while scanning a message.
+func (oc *outCache) note(atom Atom) {
+	if !oc.seen[atom] {
+		oc.seen[atom] = true
+		oc.found = append(oc.found, atom)
+	}
+}
+
+// scanAtoms returns the atoms that Write would encode for terms,
+// in order of first appearance.
+func (c *Context) scanAtoms(terms []Term) (atoms []Atom, err error) {
+	oc := c.out
+	oc.scanning = true
+	oc.seen = make(map[Atom]bool)
+	oc.found = nil
+	defer func() {
+		oc.scanning = false
+		oc.seen = nil
+		oc.found = nil
+	}()
+
+	var b []byte
+	for _, t := range terms {
+		if b, err = c.appendTerm(b[:0], t); err != nil {
+			return
+		}
+	}
+
+	return oc.found, nil
+}
+
+// assign picks cache entries for the atoms of a message. Atoms that
+// don't fit in the header or whose slot is already taken by another
+// atom of the same message are left out and get encoded in full.
+func (oc *outCache) assign(atoms []Atom) (entries []outCacheEntry) {
+	taken := make(map[uint16]bool)
+	for _, atom := range atoms {
+		if len(entries) == maxAtomCacheRefs {
+			break
+		}
+		slot := atomCacheSlot(atom)
+		if taken[slot] {
+			continue
+		}
+		taken[slot] = true
+		isNew := !oc.used[slot] || oc.atoms[slot] != atom
+		entries = append(entries, outCacheEntry{slot, atom, isNew})
+	}
+	return
+}
+
+// commit stores new entries in the cache and makes the entries the
+// references of the current message.
+func (oc *outCache) commit(entries []outCacheEntry) {
+	oc.refs = make(map[Atom]uint8, len(entries))
+	for i, e := range entries {
+		oc.atoms[e.slot] = e.atom
+		oc.used[e.slot] = true
+		oc.refs[e.atom] = uint8(i)
+	}
+}
+
+// atomCacheSlot maps an atom to its slot, segment index in the
+// high 3 bits and internal segment index in the low 8 bits.
+func atomCacheSlot(atom Atom) uint16 {
+	h := fnv.New32a()
+	h.Write([]byte(atom))
+	return uint16(h.Sum32() % atomCacheSize)
+}
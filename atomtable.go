@@ -0,0 +1,99 @@
+package etf
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultMaxAtoms is the size of an atom table made with a max of 0,
+// the default limit on atoms in an Erlang node.
+const DefaultMaxAtoms = 1 << 20
+
+// AtomTable interns atoms, so that Read returns the same Atom for
+// every occurrence of an atom instead of allocating a new one each
+// time. A table is safe for concurrent use, and may be shared by any
+// number of Contexts.
+//
+// Since a peer can send as many different atoms as it likes, a table
+// holds a bounded number of them. Once it is full, atoms that are not
+// already in it are returned without being interned.
+type AtomTable struct {
+	mu    sync.RWMutex
+	atoms map[string]Atom
+	max   int
+
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// AtomStats is a snapshot of how an AtomTable has been used.
+type AtomStats struct {
+	// Atoms is the number of atoms in the table.
+	Atoms int
+	// Hits counts the atoms that were found in the table.
+	Hits uint64
+	// Misses counts the atoms that were not found in the table,
+	// including the Dropped ones.
+	Misses uint64
+	// Dropped counts the atoms that were not added to the table
+	// because it was full.
+	Dropped uint64
+}
+
+// HitRate returns the fraction of atoms found in the table, or 0 if
+// no atoms were looked up.
+func (s AtomStats) HitRate() float64 {
+	if n := s.Hits + s.Misses; n > 0 {
+		return float64(s.Hits) / float64(n)
+	}
+	return 0
+}
+
+// NewAtomTable returns an empty table holding at most max atoms, or
+// DefaultMaxAtoms if max is 0.
+func NewAtomTable(max int) *AtomTable {
+	if max == 0 {
+		max = DefaultMaxAtoms
+	}
+	return &AtomTable{atoms: make(map[string]Atom), max: max}
+}
+
+// Stats returns the table's statistics so far.
+func (t *AtomTable) Stats() AtomStats {
+	t.mu.RLock()
+	n := len(t.atoms)
+	t.mu.RUnlock()
+	return AtomStats{
+		Atoms:   n,
+		Hits:    t.hits.Load(),
+		Misses:  t.misses.Load(),
+		Dropped: t.dropped.Load(),
+	}
+}
+
+// intern returns the atom named b, adding it to the table if it isn't
+// there and the table isn't full. The atom never shares memory with b.
+func (t *AtomTable) intern(b []byte) Atom {
+	t.mu.RLock()
+	a, ok := t.atoms[string(b)]
+	t.mu.RUnlock()
+	if ok {
+		t.hits.Add(1)
+		return a
+	}
+
+	t.misses.Add(1)
+	a = Atom(b)
+	t.mu.Lock()
+	if prev, ok := t.atoms[string(a)]; ok {
+		// added since the lookup above
+		a = prev
+	} else if len(t.atoms) < t.max {
+		t.atoms[string(a)] = a
+	} else {
+		t.dropped.Add(1)
+	}
+	t.mu.Unlock()
+	return a
+}
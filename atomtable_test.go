@@ -0,0 +1,122 @@
+package etf
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func TestAtomTable(t *testing.T) {
+	table := NewAtomTable(2)
+	for _, name := range []string{"a", "b", "a", "c", "a", "c"} {
+		if a := table.intern([]byte(name)); a != Atom(name) {
+			t.Errorf("expected %v, got %v", name, a)
+		}
+	}
+
+	exp := AtomStats{Atoms: 2, Hits: 2, Misses: 4, Dropped: 2}
+	if s := table.Stats(); s != exp {
+		t.Errorf("expected %+v, got %+v", exp, s)
+	} else if r := s.HitRate(); r != 1.0/3 {
+		t.Errorf("hit rate %v", r)
+	}
+
+	if r := NewAtomTable(0).Stats().HitRate(); r != 0 {
+		t.Errorf("hit rate %v", r)
+	}
+}
+
+func TestAtomTableConcurrent(t *testing.T) {
+	table := NewAtomTable(100)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				name := fmt.Sprintf("atom%d", j%200)
+				if a := table.intern([]byte(name)); a != Atom(name) {
+					t.Errorf("expected %v, got %v", name, a)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	s := table.Stats()
+	if s.Atoms != 100 || s.Hits+s.Misses != 8000 || s.Misses-s.Dropped != 100 {
+		t.Errorf("unexpected %+v", s)
+	}
+}
+
+func TestReadInterned(t *testing.T) {
+	c := &Context{Atoms: NewAtomTable(0)}
+
+	// {ok, ok}
+	b := []byte{104, 2, 119, 2, 111, 107, 100, 0, 2, 111, 107}
+	var atoms []Atom
+	for i := 0; i < 2; i++ {
+		v, err := c.Read(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range v.(Tuple) {
+			atoms = append(atoms, e.(Atom))
+		}
+	}
+
+	// the same string every time, not a slice of the input
+	p := unsafe.StringData(string(atoms[0]))
+	for _, a := range atoms {
+		if a != "ok" || unsafe.StringData(string(a)) != p {
+			t.Errorf("%v not interned", a)
+		}
+	}
+	if s := c.Atoms.Stats(); s.Atoms != 1 || s.Hits != 3 || s.Misses != 1 {
+		t.Errorf("unexpected %+v", s)
+	}
+
+	c.UnsafeStrings = true
+	if v, _, err := c.ReadBytes(b); err != nil {
+		t.Error(err)
+	} else if a := v.(Tuple)[0].(Atom); unsafe.StringData(string(a)) != p {
+		t.Errorf("%v not interned", a)
+	}
+
+	n := testing.AllocsPerRun(100, func() {
+		c.ReadBytes(b[2:6])
+	})
+	// the reader, and the atom in an interface
+	if n > 2 {
+		t.Errorf("%v allocations", n)
+	}
+}
+
+func TestReadDistInterned(t *testing.T) {
+	c := new(Context)
+	peer := &Context{Atoms: NewAtomTable(0)}
+	msg := Tuple{Atom("send"), Atom("ok")}
+
+	for i := 0; i < 2; i++ {
+		w := new(bytes.Buffer)
+		if err := c.WriteDist(w, []Term{msg}); err != nil {
+			t.Fatal(err)
+		} else if err := c.Write(w, msg); err != nil {
+			t.Fatal(err)
+		} else if err := peer.ReadDist(w); err != nil {
+			t.Fatal(err)
+		} else if v, err := peer.Read(w); err != nil {
+			t.Fatal(err)
+		} else if v.(Tuple)[1] != Atom("ok") {
+			t.Errorf("unexpected %v", v)
+		}
+	}
+
+	// the header of the first message adds both atoms to the cache,
+	// the second one refers to them
+	if s := peer.Atoms.Stats(); s.Atoms != 2 || s.Misses != 2 || s.Hits != 0 {
+		t.Errorf("unexpected %+v", s)
+	}
+}
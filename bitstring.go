@@ -0,0 +1,141 @@
+package etf
+
+// BitString is an Erlang bitstring whose length in bits need not be a
+// multiple of 8. Bits is the number of bits used in the last byte, 1
+// to 8, or 0 if Bytes is empty. They are the high-order bits of that
+// byte, as in BIT_BINARY_EXT.
+type BitString struct {
+	Bytes []byte
+	Bits  uint8
+}
+
+// Len returns the length of s in bits.
+func (s BitString) Len() int {
+	if len(s.Bytes) == 0 {
+		return 0
+	}
+	return 8*(len(s.Bytes)-1) + int(s.Bits)
+}
+
+// Slice returns the bits of s from i up to, but excluding, j. Like
+// slicing a Go slice, it panics if the bounds are out of range. The
+// result does not share memory with s.
+func (s BitString) Slice(i, j int) BitString {
+	if i < 0 || j < i || j > s.Len() {
+		panic("etf: BitString slice bounds out of range")
+	}
+
+	var r BitString
+	shift := uint(i % 8)
+	for ; i < j; i += 8 {
+		k := i / 8
+		b := s.Bytes[k] << shift
+		if shift != 0 && k+1 < len(s.Bytes) {
+			b |= s.Bytes[k+1] >> (8 - shift)
+		}
+		n := j - i
+		if n > 8 {
+			n = 8
+		}
+		r = r.push(b, uint8(n))
+	}
+	return r
+}
+
+// Append returns the bits of s followed by those of t.
+func (s BitString) Append(t BitString) BitString {
+	r := s.clone(len(t.Bytes))
+	for i, b := range t.Bytes {
+		n := uint8(8)
+		if i == len(t.Bytes)-1 {
+			n = t.Bits
+		}
+		r = r.push(b, n)
+	}
+	return r
+}
+
+// AppendBits returns the bits of s followed by the n low-order bits of
+// v, most significant first, as <<S/bits, V:N>> does in Erlang.
+func (s BitString) AppendBits(v uint64, n int) BitString {
+	if n < 0 || n > 64 {
+		panic("etf: BitString can't append more than 64 bits")
+	}
+	r := s.clone((n + 7) / 8)
+	for n > 0 {
+		m := n % 8
+		if m == 0 {
+			m = 8
+		}
+		n -= m
+		r = r.push(byte(v>>uint(n))<<uint(8-m), uint8(m))
+	}
+	return r
+}
+
+// clone returns a copy of s with room for more bytes.
+func (s BitString) clone(more int) BitString {
+	b := make([]byte, len(s.Bytes), len(s.Bytes)+more)
+	copy(b, s.Bytes)
+	return BitString{b, s.Bits}
+}
+
+// push appends the n high-order bits of b to s, in place.
+func (s BitString) push(b byte, n uint8) BitString {
+	if n == 0 {
+		return s
+	}
+	b &= ^byte(0) << (8 - n)
+	if len(s.Bytes) == 0 || s.Bits == 8 {
+		s.Bytes = append(s.Bytes, b)
+		s.Bits = n
+		return s
+	}
+
+	free := 8 - s.Bits
+	s.Bytes[len(s.Bytes)-1] |= b >> s.Bits
+	if n <= free {
+		s.Bits += n
+		return s
+	}
+	s.Bytes = append(s.Bytes, b<<free)
+	s.Bits = n - free
+	return s
+}
+
+// bits returns a binary or bitstring term as a BitString.
+func bits(t Term) BitString {
+	switch v := t.(type) {
+	case BitString:
+		return v
+	case []byte:
+		if len(v) == 0 {
+			return BitString{}
+		}
+		return BitString{v, 8}
+	}
+	return BitString{}
+}
+
+// compareBits compares bitstrings bit by bit, a prefix being smaller.
+func compareBits(a, b BitString) int {
+	n := a.Len()
+	if b.Len() < n {
+		n = b.Len()
+	}
+
+	full := n / 8
+	for i := 0; i < full; i++ {
+		if r := compareUints(uint64(a.Bytes[i]), uint64(b.Bytes[i])); r != 0 {
+			return r
+		}
+	}
+	if rest := n % 8; rest != 0 {
+		mask := ^byte(0) << uint(8-rest)
+		if r := compareUints(uint64(a.Bytes[full]&mask), uint64(b.Bytes[full]&mask)); r != 0 {
+			return r
+		}
+	}
+
+	return compareInts(int64(a.Len()), int64(b.Len()))
+}
@@ -0,0 +1,74 @@
+package etf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBitString(t *testing.T) {
+	// <<1:3, 255, 5:4>>, 15 bits
+	s := BitString{}.AppendBits(1, 3).AppendBits(255, 8).AppendBits(5, 4)
+	exp := BitString{[]byte{63, 234}, 7}
+	if !reflect.DeepEqual(s, exp) {
+		t.Fatalf("expected %v, got %v", exp, s)
+	} else if s.Len() != 15 {
+		t.Errorf("expected 15 bits, got %d", s.Len())
+	}
+
+	slices := []struct {
+		i, j int
+		exp  BitString
+	}{
+		{0, 0, BitString{}},
+		{0, 3, BitString{[]byte{32}, 3}},
+		{3, 11, BitString{[]byte{255}, 8}},
+		{11, 15, BitString{[]byte{80}, 4}},
+		{1, 15, BitString{[]byte{127, 212}, 6}},
+	}
+	for _, tc := range slices {
+		if v := s.Slice(tc.i, tc.j); !reflect.DeepEqual(v, tc.exp) {
+			t.Errorf("[%d:%d]: expected %v, got %v", tc.i, tc.j, tc.exp, v)
+		}
+	}
+
+	// slicing and appending back gives the same bits
+	for i := 0; i <= s.Len(); i++ {
+		if v := s.Slice(0, i).Append(s.Slice(i, s.Len())); !reflect.DeepEqual(v, s) {
+			t.Errorf("split at %d: expected %v, got %v", i, s, v)
+		}
+	}
+
+	// the receiver is left alone
+	a := BitString{[]byte{128}, 1}
+	a.AppendBits(1, 1)
+	a.Append(a)
+	if exp := (BitString{[]byte{128}, 1}); !reflect.DeepEqual(a, exp) {
+		t.Errorf("expected %v, got %v", exp, a)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic slicing out of range")
+		}
+	}()
+	s.Slice(0, 16)
+}
+
+func TestCompareBitStrings(t *testing.T) {
+	terms := []Term{
+		[]byte{},
+		BitString{[]byte{0}, 1},
+		[]byte{0},
+		BitString{[]byte{0, 128}, 1},
+		BitString{[]byte{1}, 8},
+		BitString{[]byte{128}, 1},
+	}
+	for i := range terms {
+		for j := range terms {
+			exp := compareInts(int64(i), int64(j))
+			if r := compareTerms(terms[i], terms[j]); r != exp {
+				t.Errorf("compare(%v, %v): expected %d, got %d", terms[i], terms[j], exp, r)
+			}
+		}
+	}
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goerlang/etf"
+)
+
+const etfPath = "github.com/goerlang/etf"
+
+// kind is how the generated code handles a type.
+type kind int
+
+const (
+	kindOther  kind = iota // through AppendTerm and DecodeTerm
+	kindBool               // bool
+	kindInt                // int, int8 … int64
+	kindUint               // uint, uint8 … uint64, uintptr
+	kindFloat              // float32, float64
+	kindString             // a string, or a field with a string option
+	kindAtom               // etf.Atom
+	kindBytes              // []byte
+	kindStruct             // one of the types methods are generated for
+	kindSlice              // a slice of any of the above but kindOther
+)
+
+// goType describes the type of a field, or of the elements of a slice.
+type goType struct {
+	kind kind
+	name string  // as written in the generated code
+	bits int     // of ints, uints and floats, 0 for int, uint and uintptr
+	elem *goType // of slices
+
+	// a string type that isn't string, which only has its own encoding
+	// with a string option
+	named bool
+}
+
+// field is a field of a struct, as etf.Marshal sees it.
+type field struct {
+	name   string
+	key    string
+	format string // the string option: atom, binary or charlist
+	typ    *goType
+}
+
+// structType is a struct that methods are generated for.
+type structType struct {
+	name   string
+	tag    string
+	hasTag bool
+	asMap  bool
+	fields []field // in key order if asMap
+}
+
+// typeDecl is a type declared in the package.
+type typeDecl struct {
+	spec *ast.TypeSpec
+	etf  string // the name of the etf package in its file, if imported
+}
+
+type generator struct {
+	pkg   string
+	decls map[string]typeDecl
+	want  map[string]bool
+	buf   bytes.Buffer
+	math  bool // whether the math package is used
+}
+
+// generate returns the source of the methods for the named types of
+// the package in dir.
+func generate(dir string, names []string) ([]byte, error) {
+	g := &generator{want: make(map[string]bool)}
+	if err := g.parsePackage(dir); err != nil {
+		return nil, err
+	}
+
+	var types []*structType
+	for _, name := range names {
+		g.want[name] = true
+	}
+	for _, name := range names {
+		st, err := g.structType(name)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, st)
+	}
+
+	for _, st := range types {
+		g.marshal(st)
+		g.unmarshal(st)
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by \"etfgen -type %s\"; DO NOT EDIT.\n\n", strings.Join(names, ","))
+	fmt.Fprintf(&src, "package %s\n\n", g.pkg)
+	fmt.Fprintf(&src, "import (\n\"bytes\"\n\"fmt\"\n\"io\"\n")
+	if g.math {
+		fmt.Fprintf(&src, "\"math\"\n")
+	}
+	fmt.Fprintf(&src, "\n%q\n)\n", etfPath)
+	g.buf.WriteTo(&src)
+
+	b, err := format.Source(src.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("bad generated code: %v", err)
+	}
+	return b, nil
+}
+
+// parsePackage collects the type declarations of the package in dir.
+func (g *generator) parsePackage(dir string) error {
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return err
+	}
+	g.pkg = pkg.Name
+	g.decls = make(map[string]typeDecl)
+
+	fset := token.NewFileSet()
+	for _, name := range pkg.GoFiles {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return err
+		}
+
+		var etfName string
+		for _, imp := range f.Imports {
+			if path, _ := strconv.Unquote(imp.Path.Value); path != etfPath {
+				continue
+			} else if imp.Name != nil {
+				etfName = imp.Name.Name
+			} else {
+				etfName = "etf"
+			}
+		}
+
+		for _, d := range f.Decls {
+			if gd, ok := d.(*ast.GenDecl); ok && gd.Tok == token.TYPE {
+				for _, spec := range gd.Specs {
+					ts := spec.(*ast.TypeSpec)
+					g.decls[ts.Name.Name] = typeDecl{ts, etfName}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// structType returns the fields of the named struct as etf.Marshal
+// encodes them.
+func (g *generator) structType(name string) (*structType, error) {
+	d, ok := g.decls[name]
+	if !ok {
+		return nil, fmt.Errorf("type %s not found in package %s", name, g.pkg)
+	}
+	st, ok := d.spec.Type.(*ast.StructType)
+	if !ok || d.spec.Assign.IsValid() {
+		return nil, fmt.Errorf("%s is not a struct type", name)
+	} else if d.spec.TypeParams != nil {
+		return nil, fmt.Errorf("%s is a generic type", name)
+	}
+
+	t := &structType{name: name}
+	for _, af := range st.Fields.List {
+		names := af.Names
+		if len(names) == 0 {
+			// embedded, named after its type
+			names = []*ast.Ident{embeddedName(af.Type)}
+		}
+
+		var tag string
+		if af.Tag != nil {
+			s, _ := strconv.Unquote(af.Tag.Value)
+			tag = reflect.StructTag(s).Get("etf")
+		}
+		key, opts := parseTag(tag)
+
+		for _, id := range names {
+			if id.Name == "_" {
+				if key != "" {
+					t.tag, t.hasTag = key, true
+				}
+				t.asMap = opts["map"]
+				continue
+			} else if !id.IsExported() || key == "-" {
+				continue
+			}
+
+			f := field{name: id.Name, key: id.Name}
+			if key != "" {
+				f.key = key
+			}
+			switch {
+			case opts["atom"]:
+				f.format = "StringAtom"
+			case opts["binary"]:
+				f.format = "StringBinary"
+			case opts["charlist"]:
+				f.format = "StringCharlist"
+			}
+
+			f.typ = g.fieldType(af.Type, d.etf, f.format != "")
+			t.fields = append(t.fields, f)
+		}
+	}
+
+	if t.asMap {
+		sort.SliceStable(t.fields, func(i, j int) bool {
+			return etf.Compare(etf.Atom(t.fields[i].key), etf.Atom(t.fields[j].key)) < 0
+		})
+	}
+	return t, nil
+}
+
+func embeddedName(expr ast.Expr) *ast.Ident {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return embeddedName(e.X)
+	case *ast.SelectorExpr:
+		return e.Sel
+	case *ast.Ident:
+		return e
+	}
+	return ast.NewIdent("_")
+}
+
+func parseTag(tag string) (name string, opts map[string]bool) {
+	parts := strings.Split(tag, ",")
+	opts = make(map[string]bool, len(parts)-1)
+	for _, o := range parts[1:] {
+		opts[o] = true
+	}
+	return parts[0], opts
+}
+
+var predeclared = map[string]goType{
+	"bool":    {kind: kindBool},
+	"int":     {kind: kindInt},
+	"int8":    {kind: kindInt, bits: 8},
+	"int16":   {kind: kindInt, bits: 16},
+	"int32":   {kind: kindInt, bits: 32},
+	"rune":    {kind: kindInt, bits: 32},
+	"int64":   {kind: kindInt, bits: 64},
+	"uint":    {kind: kindUint},
+	"uint8":   {kind: kindUint, bits: 8},
+	"byte":    {kind: kindUint, bits: 8},
+	"uint16":  {kind: kindUint, bits: 16},
+	"uint32":  {kind: kindUint, bits: 32},
+	"uint64":  {kind: kindUint, bits: 64},
+	"uintptr": {kind: kindUint},
+	"float32": {kind: kindFloat, bits: 32},
+	"float64": {kind: kindFloat, bits: 64},
+	"string":  {kind: kindString},
+}
+
+// fieldType returns the type of a field, which has a string option if
+// str is set.
+func (g *generator) fieldType(expr ast.Expr, etfName string, str bool) *goType {
+	t := g.goType(expr, etfName)
+	switch {
+	case str && t.kind == kindAtom:
+		return &goType{kind: kindString, name: t.name, named: true}
+	case !str && t.named:
+		// etf.Write can't encode it
+		return &goType{kind: kindOther}
+	}
+	return t
+}
+
+// goType returns the type of expr, in a file that imports etf as
+// etfName.
+func (g *generator) goType(expr ast.Expr, etfName string) *goType {
+	other := &goType{kind: kindOther}
+
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if d, ok := g.decls[e.Name]; ok {
+			if d.spec.Assign.IsValid() {
+				return g.goType(d.spec.Type, d.etf)
+			} else if g.want[e.Name] {
+				return &goType{kind: kindStruct, name: e.Name}
+			} else if id, ok := d.spec.Type.(*ast.Ident); ok && id.Name == "string" {
+				return &goType{kind: kindString, name: e.Name, named: true}
+			}
+			return other
+		}
+		if t, ok := predeclared[e.Name]; ok {
+			t.name = e.Name
+			return &t
+		}
+
+	case *ast.SelectorExpr:
+		if x, ok := e.X.(*ast.Ident); ok && x.Name == etfName && e.Sel.Name == "Atom" {
+			return &goType{kind: kindAtom, name: "etf.Atom"}
+		}
+
+	case *ast.ParenExpr:
+		return g.goType(e.X, etfName)
+
+	case *ast.ArrayType:
+		if e.Len != nil {
+			break
+		}
+		elem := g.goType(e.Elt, etfName)
+		if elem.kind == kindUint && elem.bits == 8 {
+			return &goType{kind: kindBytes, name: "[]byte"}
+		} else if elem.kind != kindOther && !elem.named {
+			return &goType{kind: kindSlice, name: "[]" + elem.name, elem: elem}
+		}
+	}
+
+	return other
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// marshal generates MarshalETF, and appendETF which appends to a
+// buffer, for the types of nested fields.
+func (g *generator) marshal(st *structType) {
+	g.printf("\n// MarshalETF implements etf.Marshaler.\n")
+	g.printf("func (v %s) MarshalETF() ([]byte, error) {\n", st.name)
+	g.printf("return v.appendETF(nil)\n}\n")
+
+	g.printf("\nfunc (v *%s) appendETF(b []byte) (_ []byte, err error) {\n", st.name)
+	if st.asMap {
+		g.printf("b = etf.AppendMapHead(b, %d)\n", len(st.fields))
+	} else if st.hasTag {
+		g.printf("b = etf.AppendTupleHead(b, %d)\n", len(st.fields)+1)
+		g.printf("if b, err = etf.AppendAtom(b, %q); err != nil {\nreturn\n}\n", st.tag)
+	} else {
+		g.printf("b = etf.AppendTupleHead(b, %d)\n", len(st.fields))
+	}
+
+	for _, f := range st.fields {
+		if st.asMap {
+			g.printf("if b, err = etf.AppendAtom(b, %q); err != nil {\nreturn\n}\n", f.key)
+		}
+		g.encode("v."+f.name, f.typ, f.format, 0)
+	}
+	g.printf("return b, nil\n}\n")
+}
+
+// encode generates the code appending x, of type t.
+func (g *generator) encode(x string, t *goType, format string, depth int) {
+	check := "; err != nil {\nreturn\n}\n"
+	switch t.kind {
+	case kindBool:
+		g.printf("b = etf.AppendBool(b, %s)\n", x)
+	case kindInt:
+		g.printf("b = etf.AppendInt(b, int64(%s))\n", x)
+	case kindUint:
+		g.printf("b = etf.AppendUint(b, uint64(%s))\n", x)
+	case kindFloat:
+		g.printf("b = etf.AppendFloat(b, %s)\n", convert("float64", t.name, x))
+	case kindString:
+		if format == "" {
+			format = "StringDefault"
+		}
+		g.printf("if b, err = etf.AppendString(b, %s, etf.%s)"+check, convert("string", t.name, x), format)
+	case kindAtom:
+		g.printf("if b, err = etf.AppendAtom(b, %s)"+check, x)
+	case kindBytes:
+		g.printf("if b, err = etf.AppendBinary(b, %s)"+check, x)
+	case kindStruct:
+		g.printf("if b, err = %s.appendETF(b)"+check, x)
+	case kindSlice:
+		i := loopVar("i", depth)
+		g.printf("if len(%s) == 0 {\nb = etf.AppendNil(b)\n} else {\n", x)
+		g.printf("b = etf.AppendListHead(b, len(%s))\n", x)
+		g.printf("for %s := range %s {\n", i, x)
+		g.encode(x+"["+i+"]", t.elem, "", depth+1)
+		g.printf("}\nb = etf.AppendNil(b)\n}\n")
+	default:
+		g.printf("if b, err = new(etf.Context).AppendTerm(b, %s)"+check, x)
+	}
+}
+
+// unmarshal generates UnmarshalETF, and decodeETF which decodes a term
+// read already, for the types of nested fields.
+func (g *generator) unmarshal(st *structType) {
+	typeName := g.pkg + "." + st.name
+
+	g.printf("\n// UnmarshalETF implements etf.Unmarshaler.\n")
+	g.printf("func (v *%s) UnmarshalETF(b []byte) error {\n", st.name)
+	g.printf(`r := bytes.NewReader(b)
+		term, err := new(etf.Context).Read(r)
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		} else if r.Len() != 0 {
+			return fmt.Errorf("read: %%d bytes left after term", r.Len())
+		}
+		return v.decodeETF(term)
+	}
+	`)
+
+	g.printf("\nfunc (v *%s) decodeETF(term etf.Term) (err error) {\n", st.name)
+	if st.asMap {
+		g.decodeMap(st, typeName)
+	} else {
+		g.decodeTuple(st, typeName)
+	}
+	g.printf("return\n}\n")
+}
+
+func (g *generator) decodeTuple(st *structType, typeName string) {
+	g.printf("t, ok := term.(etf.Tuple)\nif !ok {\n")
+	g.printf("return fmt.Errorf(\"decode: can't store %%T into %s\", term)\n}\n", typeName)
+	if st.hasTag {
+		g.printf("if len(t) == 0 || t[0] != etf.Atom(%q) {\n", st.tag)
+		g.printf("return fmt.Errorf(\"decode: can't store %%v into %s, expected a %s record\", t)\n}\n",
+			typeName, st.tag)
+		g.printf("t = t[1:]\n")
+	}
+	g.printf("if len(t) != %d {\n", len(st.fields))
+	g.printf("return fmt.Errorf(\"decode: can't store tuple of %%d into %s\", len(t))\n}\n", typeName)
+
+	for i, f := range st.fields {
+		g.decode("v."+f.name, fmt.Sprintf("t[%d]", i), f.typ, f.format, 0)
+	}
+}
+
+// decodeMap generates the decoding of the values of a map into the
+// fields named by their keys, which may be atoms, binaries or strings.
+// As with DecodeTerm, the last value of a key is the one stored, and
+// fields are decoded in order.
+func (g *generator) decodeMap(st *structType, typeName string) {
+	g.printf("m, ok := term.(etf.Map)\nif !ok {\n")
+	g.printf("return fmt.Errorf(\"decode: can't store %%T into %s\", term)\n}\n", typeName)
+	if len(st.fields) == 0 {
+		return
+	}
+
+	var keys []string
+	values := make(map[string]string)
+	for _, f := range st.fields {
+		if _, ok := values[f.key]; !ok {
+			keys = append(keys, f.key)
+			values[f.key] = loopVar("val", len(keys))
+		}
+	}
+
+	g.printf("var %s etf.Term\n", strings.Join(valueNames(keys, values), ", "))
+	g.printf("for _, e := range m {\nvar key string\n")
+	g.printf("switch k := e.Key.(type) {\n")
+	g.printf("case etf.Atom:\nkey = string(k)\n")
+	g.printf("case []byte:\nkey = string(k)\n")
+	g.printf("case string:\nkey = k\n")
+	g.printf("}\n")
+	g.printf("switch key {\n")
+	for _, k := range keys {
+		g.printf("case %q:\n%s = e.Value\n", k, values[k])
+	}
+	g.printf("}\n}\n")
+
+	for _, f := range st.fields {
+		g.printf("if %s != nil {\n", values[f.key])
+		g.decode("v."+f.name, values[f.key], f.typ, f.format, 0)
+		g.printf("}\n")
+	}
+}
+
+func valueNames(keys []string, values map[string]string) []string {
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = values[k]
+	}
+	return names
+}
+
+// decode generates the code storing the term src into x, of type t.
+func (g *generator) decode(x, src string, t *goType, format string, depth int) {
+	fallback := fmt.Sprintf("if err = etf.DecodeTerm(%s, &%s); err != nil {\nreturn\n}\n", src, x)
+
+	// the terms that Read returns for t, and how to convert them
+	var termType, cond, value string
+	switch t.kind {
+	case kindBool:
+		termType, value = "bool", "x"
+	case kindInt:
+		termType, value = "int", convert(t.name, "int", "x")
+		if t.bits > 0 && t.bits < 64 {
+			cond = fmt.Sprintf(" && x >= math.MinInt%d && x <= math.MaxInt%[1]d", t.bits)
+			g.math = true
+		}
+	case kindUint:
+		termType, value = "int", t.name+"(x)"
+		cond = " && x >= 0"
+		if t.bits > 0 && t.bits < 64 {
+			// compared as uint64, since MaxUint32 overflows a 32-bit int
+			cond += fmt.Sprintf(" && uint64(x) <= math.MaxUint%d", t.bits)
+			g.math = true
+		}
+	case kindFloat:
+		termType, value = "float64", convert(t.name, "float64", "x")
+		if t.bits == 32 {
+			cond = " && math.Abs(x) <= math.MaxFloat32"
+			g.math = true
+		}
+	case kindString:
+		switch format {
+		case "StringAtom":
+			termType = "etf.Atom"
+		case "StringBinary":
+			termType = "[]byte"
+		default:
+			termType = "string"
+		}
+		value = convert(t.name, termType, "x")
+	case kindAtom:
+		termType, value = "etf.Atom", "x"
+	case kindBytes:
+		termType, value = "[]byte", "x"
+
+	case kindStruct:
+		g.printf("if err = %s.decodeETF(%s); err != nil {\nreturn\n}\n", x, src)
+		return
+
+	case kindSlice:
+		l, i := loopVar("l", depth), loopVar("i", depth)
+		g.printf("if %s, ok := %s.(etf.List); ok {\n", l, src)
+		g.printf("%s = make(%s, len(%s))\n", x, t.name, l)
+		g.printf("for %s := range %s {\n", i, l)
+		g.decode(x+"["+i+"]", l+"["+i+"]", t.elem, "", depth+1)
+		g.printf("}\n} else ")
+		g.buf.WriteString(fallback)
+		return
+
+	default:
+		g.buf.WriteString(fallback)
+		return
+	}
+
+	g.printf("if x, ok := %s.(%s); ok%s {\n%s = %s\n} else ", src, termType, cond, x, value)
+	g.buf.WriteString(fallback)
+}
+
+// convert returns the Go expression converting x of type from to type
+// to.
+func convert(to, from, x string) string {
+	if to == from {
+		return x
+	}
+	return to + "(" + x + ")"
+}
+
+// loopVar names the variables of nested loops.
+func loopVar(name string, depth int) string {
+	if depth == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s%d", name, depth)
+}
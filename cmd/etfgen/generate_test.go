@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	dir := filepath.Join("internal", "example")
+	want, err := os.ReadFile(filepath.Join(dir, "person_etf.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := generate(dir, []string{"Person", "Address", "Point", "Options"})
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, want) {
+		t.Errorf("generated code differs from %s, run go generate in %[2]s", "person_etf.go", dir)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	dir := filepath.Join("internal", "example")
+	tests := []struct {
+		types []string
+		err   string
+	}{
+		{[]string{"Missing"}, "type Missing not found"},
+		{[]string{"Level"}, "Level is not a struct type"},
+	}
+
+	for _, test := range tests {
+		_, err := generate(dir, test.types)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: got error %v, want %q", test.types, err, test.err)
+		}
+	}
+
+	if _, err := generate("missing", []string{"T"}); err == nil {
+		t.Errorf("missing dir: got no error")
+	}
+}
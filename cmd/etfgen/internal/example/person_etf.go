@@ -0,0 +1,487 @@
+// Code generated by "etfgen -type Person,Address,Point,Options"; DO NOT EDIT.
+
+package example
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/goerlang/etf"
+)
+
+// MarshalETF implements etf.Marshaler.
+func (v Person) MarshalETF() ([]byte, error) {
+	return v.appendETF(nil)
+}
+
+func (v *Person) appendETF(b []byte) (_ []byte, err error) {
+	b = etf.AppendTupleHead(b, 14)
+	if b, err = etf.AppendAtom(b, "person"); err != nil {
+		return
+	}
+	if b, err = etf.AppendString(b, v.Name, etf.StringBinary); err != nil {
+		return
+	}
+	if b, err = etf.AppendString(b, v.Nick, etf.StringCharlist); err != nil {
+		return
+	}
+	if b, err = etf.AppendString(b, v.Role, etf.StringAtom); err != nil {
+		return
+	}
+	if b, err = etf.AppendAtom(b, v.Status); err != nil {
+		return
+	}
+	b = etf.AppendInt(b, int64(v.Age))
+	b = etf.AppendFloat(b, v.Height)
+	if len(v.Emails) == 0 {
+		b = etf.AppendNil(b)
+	} else {
+		b = etf.AppendListHead(b, len(v.Emails))
+		for i := range v.Emails {
+			if b, err = etf.AppendString(b, v.Emails[i], etf.StringDefault); err != nil {
+				return
+			}
+		}
+		b = etf.AppendNil(b)
+	}
+	if len(v.Scores) == 0 {
+		b = etf.AppendNil(b)
+	} else {
+		b = etf.AppendListHead(b, len(v.Scores))
+		for i := range v.Scores {
+			b = etf.AppendFloat(b, v.Scores[i])
+		}
+		b = etf.AppendNil(b)
+	}
+	if b, err = v.Home.appendETF(b); err != nil {
+		return
+	}
+	if len(v.Places) == 0 {
+		b = etf.AppendNil(b)
+	} else {
+		b = etf.AppendListHead(b, len(v.Places))
+		for i := range v.Places {
+			if b, err = v.Places[i].appendETF(b); err != nil {
+				return
+			}
+		}
+		b = etf.AppendNil(b)
+	}
+	if b, err = etf.AppendBinary(b, v.Avatar); err != nil {
+		return
+	}
+	if b, err = new(etf.Context).AppendTerm(b, v.Labels); err != nil {
+		return
+	}
+	if b, err = new(etf.Context).AppendTerm(b, v.Extra); err != nil {
+		return
+	}
+	return b, nil
+}
+
+// UnmarshalETF implements etf.Unmarshaler.
+func (v *Person) UnmarshalETF(b []byte) error {
+	r := bytes.NewReader(b)
+	term, err := new(etf.Context).Read(r)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	} else if r.Len() != 0 {
+		return fmt.Errorf("read: %d bytes left after term", r.Len())
+	}
+	return v.decodeETF(term)
+}
+
+func (v *Person) decodeETF(term etf.Term) (err error) {
+	t, ok := term.(etf.Tuple)
+	if !ok {
+		return fmt.Errorf("decode: can't store %T into example.Person", term)
+	}
+	if len(t) == 0 || t[0] != etf.Atom("person") {
+		return fmt.Errorf("decode: can't store %v into example.Person, expected a person record", t)
+	}
+	t = t[1:]
+	if len(t) != 13 {
+		return fmt.Errorf("decode: can't store tuple of %d into example.Person", len(t))
+	}
+	if x, ok := t[0].([]byte); ok {
+		v.Name = string(x)
+	} else if err = etf.DecodeTerm(t[0], &v.Name); err != nil {
+		return
+	}
+	if x, ok := t[1].(string); ok {
+		v.Nick = x
+	} else if err = etf.DecodeTerm(t[1], &v.Nick); err != nil {
+		return
+	}
+	if x, ok := t[2].(etf.Atom); ok {
+		v.Role = string(x)
+	} else if err = etf.DecodeTerm(t[2], &v.Role); err != nil {
+		return
+	}
+	if x, ok := t[3].(etf.Atom); ok {
+		v.Status = x
+	} else if err = etf.DecodeTerm(t[3], &v.Status); err != nil {
+		return
+	}
+	if x, ok := t[4].(int); ok {
+		v.Age = x
+	} else if err = etf.DecodeTerm(t[4], &v.Age); err != nil {
+		return
+	}
+	if x, ok := t[5].(float64); ok {
+		v.Height = x
+	} else if err = etf.DecodeTerm(t[5], &v.Height); err != nil {
+		return
+	}
+	if l, ok := t[6].(etf.List); ok {
+		v.Emails = make([]string, len(l))
+		for i := range l {
+			if x, ok := l[i].(string); ok {
+				v.Emails[i] = x
+			} else if err = etf.DecodeTerm(l[i], &v.Emails[i]); err != nil {
+				return
+			}
+		}
+	} else if err = etf.DecodeTerm(t[6], &v.Emails); err != nil {
+		return
+	}
+	if l, ok := t[7].(etf.List); ok {
+		v.Scores = make([]float64, len(l))
+		for i := range l {
+			if x, ok := l[i].(float64); ok {
+				v.Scores[i] = x
+			} else if err = etf.DecodeTerm(l[i], &v.Scores[i]); err != nil {
+				return
+			}
+		}
+	} else if err = etf.DecodeTerm(t[7], &v.Scores); err != nil {
+		return
+	}
+	if err = v.Home.decodeETF(t[8]); err != nil {
+		return
+	}
+	if l, ok := t[9].(etf.List); ok {
+		v.Places = make([]Address, len(l))
+		for i := range l {
+			if err = v.Places[i].decodeETF(l[i]); err != nil {
+				return
+			}
+		}
+	} else if err = etf.DecodeTerm(t[9], &v.Places); err != nil {
+		return
+	}
+	if x, ok := t[10].([]byte); ok {
+		v.Avatar = x
+	} else if err = etf.DecodeTerm(t[10], &v.Avatar); err != nil {
+		return
+	}
+	if err = etf.DecodeTerm(t[11], &v.Labels); err != nil {
+		return
+	}
+	if err = etf.DecodeTerm(t[12], &v.Extra); err != nil {
+		return
+	}
+	return
+}
+
+// MarshalETF implements etf.Marshaler.
+func (v Address) MarshalETF() ([]byte, error) {
+	return v.appendETF(nil)
+}
+
+func (v *Address) appendETF(b []byte) (_ []byte, err error) {
+	b = etf.AppendTupleHead(b, 3)
+	if b, err = etf.AppendString(b, v.Street, etf.StringBinary); err != nil {
+		return
+	}
+	b = etf.AppendUint(b, uint64(v.Number))
+	if b, err = v.Geo.appendETF(b); err != nil {
+		return
+	}
+	return b, nil
+}
+
+// UnmarshalETF implements etf.Unmarshaler.
+func (v *Address) UnmarshalETF(b []byte) error {
+	r := bytes.NewReader(b)
+	term, err := new(etf.Context).Read(r)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	} else if r.Len() != 0 {
+		return fmt.Errorf("read: %d bytes left after term", r.Len())
+	}
+	return v.decodeETF(term)
+}
+
+func (v *Address) decodeETF(term etf.Term) (err error) {
+	t, ok := term.(etf.Tuple)
+	if !ok {
+		return fmt.Errorf("decode: can't store %T into example.Address", term)
+	}
+	if len(t) != 3 {
+		return fmt.Errorf("decode: can't store tuple of %d into example.Address", len(t))
+	}
+	if x, ok := t[0].([]byte); ok {
+		v.Street = string(x)
+	} else if err = etf.DecodeTerm(t[0], &v.Street); err != nil {
+		return
+	}
+	if x, ok := t[1].(int); ok && x >= 0 && uint64(x) <= math.MaxUint16 {
+		v.Number = uint16(x)
+	} else if err = etf.DecodeTerm(t[1], &v.Number); err != nil {
+		return
+	}
+	if err = v.Geo.decodeETF(t[2]); err != nil {
+		return
+	}
+	return
+}
+
+// MarshalETF implements etf.Marshaler.
+func (v Point) MarshalETF() ([]byte, error) {
+	return v.appendETF(nil)
+}
+
+func (v *Point) appendETF(b []byte) (_ []byte, err error) {
+	b = etf.AppendTupleHead(b, 3)
+	b = etf.AppendFloat(b, float64(v.X))
+	b = etf.AppendFloat(b, float64(v.Y))
+	b = etf.AppendInt(b, int64(v.Z))
+	return b, nil
+}
+
+// UnmarshalETF implements etf.Unmarshaler.
+func (v *Point) UnmarshalETF(b []byte) error {
+	r := bytes.NewReader(b)
+	term, err := new(etf.Context).Read(r)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	} else if r.Len() != 0 {
+		return fmt.Errorf("read: %d bytes left after term", r.Len())
+	}
+	return v.decodeETF(term)
+}
+
+func (v *Point) decodeETF(term etf.Term) (err error) {
+	t, ok := term.(etf.Tuple)
+	if !ok {
+		return fmt.Errorf("decode: can't store %T into example.Point", term)
+	}
+	if len(t) != 3 {
+		return fmt.Errorf("decode: can't store tuple of %d into example.Point", len(t))
+	}
+	if x, ok := t[0].(float64); ok && math.Abs(x) <= math.MaxFloat32 {
+		v.X = float32(x)
+	} else if err = etf.DecodeTerm(t[0], &v.X); err != nil {
+		return
+	}
+	if x, ok := t[1].(float64); ok && math.Abs(x) <= math.MaxFloat32 {
+		v.Y = float32(x)
+	} else if err = etf.DecodeTerm(t[1], &v.Y); err != nil {
+		return
+	}
+	if x, ok := t[2].(int); ok && x >= math.MinInt8 && x <= math.MaxInt8 {
+		v.Z = int8(x)
+	} else if err = etf.DecodeTerm(t[2], &v.Z); err != nil {
+		return
+	}
+	return
+}
+
+// MarshalETF implements etf.Marshaler.
+func (v Options) MarshalETF() ([]byte, error) {
+	return v.appendETF(nil)
+}
+
+func (v *Options) appendETF(b []byte) (_ []byte, err error) {
+	b = etf.AppendMapHead(b, 6)
+	if b, err = etf.AppendAtom(b, "Debug"); err != nil {
+		return
+	}
+	b = etf.AppendBool(b, v.Debug)
+	if b, err = etf.AppendAtom(b, "chunks"); err != nil {
+		return
+	}
+	if len(v.Chunks) == 0 {
+		b = etf.AppendNil(b)
+	} else {
+		b = etf.AppendListHead(b, len(v.Chunks))
+		for i := range v.Chunks {
+			if b, err = etf.AppendBinary(b, v.Chunks[i]); err != nil {
+				return
+			}
+		}
+		b = etf.AppendNil(b)
+	}
+	if b, err = etf.AppendAtom(b, "flags"); err != nil {
+		return
+	}
+	if len(v.Flags) == 0 {
+		b = etf.AppendNil(b)
+	} else {
+		b = etf.AppendListHead(b, len(v.Flags))
+		for i := range v.Flags {
+			if b, err = etf.AppendAtom(b, v.Flags[i]); err != nil {
+				return
+			}
+		}
+		b = etf.AppendNil(b)
+	}
+	if b, err = etf.AppendAtom(b, "grid"); err != nil {
+		return
+	}
+	if len(v.Grid) == 0 {
+		b = etf.AppendNil(b)
+	} else {
+		b = etf.AppendListHead(b, len(v.Grid))
+		for i := range v.Grid {
+			if len(v.Grid[i]) == 0 {
+				b = etf.AppendNil(b)
+			} else {
+				b = etf.AppendListHead(b, len(v.Grid[i]))
+				for i1 := range v.Grid[i] {
+					b = etf.AppendInt(b, int64(v.Grid[i][i1]))
+				}
+				b = etf.AppendNil(b)
+			}
+		}
+		b = etf.AppendNil(b)
+	}
+	if b, err = etf.AppendAtom(b, "level"); err != nil {
+		return
+	}
+	if b, err = etf.AppendString(b, string(v.Level), etf.StringAtom); err != nil {
+		return
+	}
+	if b, err = etf.AppendAtom(b, "limit"); err != nil {
+		return
+	}
+	b = etf.AppendUint(b, uint64(v.Limit))
+	return b, nil
+}
+
+// UnmarshalETF implements etf.Unmarshaler.
+func (v *Options) UnmarshalETF(b []byte) error {
+	r := bytes.NewReader(b)
+	term, err := new(etf.Context).Read(r)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	} else if r.Len() != 0 {
+		return fmt.Errorf("read: %d bytes left after term", r.Len())
+	}
+	return v.decodeETF(term)
+}
+
+func (v *Options) decodeETF(term etf.Term) (err error) {
+	m, ok := term.(etf.Map)
+	if !ok {
+		return fmt.Errorf("decode: can't store %T into example.Options", term)
+	}
+	var val1, val2, val3, val4, val5, val6 etf.Term
+	for _, e := range m {
+		var key string
+		switch k := e.Key.(type) {
+		case etf.Atom:
+			key = string(k)
+		case []byte:
+			key = string(k)
+		case string:
+			key = k
+		}
+		switch key {
+		case "Debug":
+			val1 = e.Value
+		case "chunks":
+			val2 = e.Value
+		case "flags":
+			val3 = e.Value
+		case "grid":
+			val4 = e.Value
+		case "level":
+			val5 = e.Value
+		case "limit":
+			val6 = e.Value
+		}
+	}
+	if val1 != nil {
+		if x, ok := val1.(bool); ok {
+			v.Debug = x
+		} else if err = etf.DecodeTerm(val1, &v.Debug); err != nil {
+			return
+		}
+	}
+	if val2 != nil {
+		if l, ok := val2.(etf.List); ok {
+			v.Chunks = make([][]byte, len(l))
+			for i := range l {
+				if x, ok := l[i].([]byte); ok {
+					v.Chunks[i] = x
+				} else if err = etf.DecodeTerm(l[i], &v.Chunks[i]); err != nil {
+					return
+				}
+			}
+		} else if err = etf.DecodeTerm(val2, &v.Chunks); err != nil {
+			return
+		}
+	}
+	if val3 != nil {
+		if l, ok := val3.(etf.List); ok {
+			v.Flags = make([]etf.Atom, len(l))
+			for i := range l {
+				if x, ok := l[i].(etf.Atom); ok {
+					v.Flags[i] = x
+				} else if err = etf.DecodeTerm(l[i], &v.Flags[i]); err != nil {
+					return
+				}
+			}
+		} else if err = etf.DecodeTerm(val3, &v.Flags); err != nil {
+			return
+		}
+	}
+	if val4 != nil {
+		if l, ok := val4.(etf.List); ok {
+			v.Grid = make([][]int, len(l))
+			for i := range l {
+				if l1, ok := l[i].(etf.List); ok {
+					v.Grid[i] = make([]int, len(l1))
+					for i1 := range l1 {
+						if x, ok := l1[i1].(int); ok {
+							v.Grid[i][i1] = x
+						} else if err = etf.DecodeTerm(l1[i1], &v.Grid[i][i1]); err != nil {
+							return
+						}
+					}
+				} else if err = etf.DecodeTerm(l[i], &v.Grid[i]); err != nil {
+					return
+				}
+			}
+		} else if err = etf.DecodeTerm(val4, &v.Grid); err != nil {
+			return
+		}
+	}
+	if val5 != nil {
+		if x, ok := val5.(etf.Atom); ok {
+			v.Level = Level(x)
+		} else if err = etf.DecodeTerm(val5, &v.Level); err != nil {
+			return
+		}
+	}
+	if val6 != nil {
+		if x, ok := val6.(int); ok && x >= 0 {
+			v.Limit = uint64(x)
+		} else if err = etf.DecodeTerm(val6, &v.Limit); err != nil {
+			return
+		}
+	}
+	return
+}
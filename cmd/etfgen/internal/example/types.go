@@ -0,0 +1,54 @@
+// Package example has types with methods generated by etfgen, which
+// its tests compare with the reflective encoding.
+package example
+
+import "github.com/goerlang/etf"
+
+//go:generate go run github.com/goerlang/etf/cmd/etfgen -type Person,Address,Point,Options
+
+// Person is a record, {person, Name, Nick, Role, ...}.
+type Person struct {
+	_        struct{} `etf:"person"`
+	Name     string   `etf:"name,binary"`
+	Nick     string   `etf:"nick,charlist"`
+	Role     string   `etf:"role,atom"`
+	Status   etf.Atom `etf:"status"`
+	Age      int
+	Height   float64
+	Emails   []string
+	Scores   []float64
+	Home     Address
+	Places   []Address
+	Avatar   []byte
+	Labels   map[string]int
+	Extra    interface{}
+	Password string `etf:"-"`
+	secret   string
+}
+
+// Address is a tuple, {Street, Number, Geo}.
+type Address struct {
+	Street string `etf:",binary"`
+	Number uint16
+	Geo    Point
+}
+
+// Point is a tuple, {X, Y, Z}.
+type Point struct {
+	X, Y float32
+	Z    int8
+}
+
+// Level is a string encoded as an atom.
+type Level string
+
+// Options is a map, #{'Debug' => ..., level => ..., ...}.
+type Options struct {
+	_      struct{} `etf:",map"`
+	Level  Level    `etf:"level,atom"`
+	Limit  uint64   `etf:"limit"`
+	Chunks [][]byte `etf:"chunks"`
+	Grid   [][]int  `etf:"grid"`
+	Debug  bool
+	Flags  []etf.Atom `etf:"flags"`
+}
@@ -0,0 +1,297 @@
+package example
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/goerlang/etf"
+)
+
+// The plain types have the fields and tags of the generated ones, but
+// no methods, so that they go through the reflective encoding. Their
+// fields of generated types are covered by the tests of those types.
+type (
+	plainPerson  Person
+	plainAddress Address
+	plainPoint   Point
+	plainOptions Options
+)
+
+var testPerson = Person{
+	Name:   "Joe",
+	Nick:   "joe",
+	Role:   "admin",
+	Status: "active",
+	Age:    42,
+	Height: 1.82,
+	Emails: []string{"joe@example.com", ""},
+	Scores: []float64{0.5, -3},
+	Home:   Address{"Main St", 10, Point{1.5, -2, 3}},
+	Places: []Address{{}, {"Elm St", 65535, Point{Z: -128}}},
+	Avatar: []byte{0, 1, 2},
+	Labels: map[string]int{"a": 1},
+	Extra:  etf.Tuple{etf.Atom("ok"), 1},
+}
+
+var testOptions = Options{
+	Level:  "debug",
+	Limit:  math.MaxUint64,
+	Chunks: [][]byte{{1}, {}, nil},
+	Grid:   [][]int{{1, 2}, {}, {-1 << 40}},
+	Debug:  true,
+	Flags:  []etf.Atom{"a", "b"},
+}
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		gen, plain interface{}
+	}{
+		{Person{}, plainPerson{}},
+		{testPerson, plainPerson(testPerson)},
+		{&testPerson, (*plainPerson)(&testPerson)},
+		{
+			Person{Name: "Jöns", Nick: "ヨンス", Role: "rôle", Age: math.MinInt64, Height: math.MaxFloat64, Emails: []string{}, Extra: big.NewInt(1)},
+			plainPerson{Name: "Jöns", Nick: "ヨンス", Role: "rôle", Age: math.MinInt64, Height: math.MaxFloat64, Emails: []string{}, Extra: big.NewInt(1)},
+		},
+		{Person{Role: "bad\xff"}, plainPerson{Role: "bad\xff"}},
+		{Person{Status: etf.Atom(make([]byte, 256))}, plainPerson{Status: etf.Atom(make([]byte, 256))}},
+		{Person{Extra: struct{}{}}, plainPerson{Extra: struct{}{}}},
+		{Address{}, plainAddress{}},
+		{Address{"Main St", 10, Point{1.5, -2, 3}}, plainAddress{"Main St", 10, Point{1.5, -2, 3}}},
+		{Point{math.MaxFloat32, -math.SmallestNonzeroFloat32, math.MinInt8}, plainPoint{math.MaxFloat32, -math.SmallestNonzeroFloat32, math.MinInt8}},
+		{Options{}, plainOptions{}},
+		{testOptions, plainOptions(testOptions)},
+		{Options{Level: "bad\xff"}, plainOptions{Level: "bad\xff"}},
+	}
+
+	for _, test := range tests {
+		want, wantErr := etf.Marshal(test.plain)
+		got, err := etf.Marshal(test.gen)
+		if fmt.Sprint(err) != fmt.Sprint(wantErr) {
+			t.Errorf("%#v: got error %v, want %v", test.gen, err, wantErr)
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%#v: got %v, want %v", test.gen, got, want)
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	person := etf.Tuple{
+		etf.Atom("person"), []byte("Joe"), "joe", etf.Atom("admin"), etf.Atom("active"),
+		42, 1.82, etf.List{"joe@example.com", []byte("x")}, etf.List{0.5, -3.0},
+		etf.Tuple{[]byte("Main St"), 10, etf.Tuple{1.5, -2.0, 3}},
+		etf.List{etf.Tuple{[]byte(""), 0, etf.Tuple{0.0, 0.0, 0}}},
+		[]byte{0, 1, 2}, mapOf("a", 1), etf.Tuple{etf.Atom("ok"), 1},
+	}
+	with := func(i int, v etf.Term) etf.Tuple {
+		t := append(etf.Tuple{}, person...)
+		t[i] = v
+		return t
+	}
+
+	persons := []etf.Term{
+		person,
+		person[1:],
+		etf.Tuple{etf.Atom("people")},
+		etf.Tuple{},
+		42,
+		with(1, "Joe"),
+		with(1, etf.Atom("joe")),
+		with(1, 1),
+		with(2, []byte("joe")),
+		with(2, etf.List{0x30e8, 0x30f3}),
+		with(2, "j\xf6ns"),
+		with(7, etf.List{"j\xf6ns@example.com"}),
+		with(3, []byte("admin")),
+		with(3, true),
+		with(4, "active"),
+		with(5, int64(-1)<<40),
+		with(5, new(big.Int).Lsh(big.NewInt(1), 70)),
+		with(5, 1.5),
+		with(6, 3),
+		with(6, etf.Atom("x")),
+		with(7, etf.List{}),
+		with(7, "abc"),
+		with(7, etf.List{1}),
+		with(7, 1),
+		with(8, "abc"),
+		with(8, etf.List{etf.Atom("x")}),
+		with(9, etf.Tuple{}),
+		with(9, etf.Tuple{[]byte(""), -1, etf.Tuple{0.0, 0.0, 0}}),
+		with(10, etf.List{1}),
+		with(10, etf.List{}),
+		with(10, etf.Atom("x")),
+		with(11, "abc"),
+		with(11, etf.List{1, 2}),
+		with(12, mapOf(etf.Atom("a"), 1)),
+		with(13, etf.List{}),
+	}
+	for _, term := range persons {
+		testUnmarshal(t, term, new(Person), new(plainPerson))
+	}
+
+	addresses := []etf.Term{
+		etf.Tuple{[]byte("Main St"), 65535, etf.Tuple{1.0, 2.0, 3}},
+		etf.Tuple{[]byte("Main St"), 65536, etf.Tuple{1.0, 2.0, 3}},
+		etf.Tuple{[]byte("Main St"), -1, etf.Tuple{1.0, 2.0, 3}},
+		etf.Tuple{"Main St", int64(-1) << 40, etf.Tuple{1.0, 2.0, 3}},
+		etf.Tuple{[]byte("Main St"), 1},
+		etf.List{},
+	}
+	for _, term := range addresses {
+		testUnmarshal(t, term, new(Address), new(plainAddress))
+	}
+
+	points := []etf.Term{
+		etf.Tuple{1.5, -2.0, 3},
+		etf.Tuple{1, 2, -128},
+		etf.Tuple{1.0, 2.0, 128},
+		etf.Tuple{1.0, 2.0, -129},
+		etf.Tuple{math.MaxFloat32, -math.MaxFloat32, 0},
+		etf.Tuple{math.MaxFloat64, 0.0, 0},
+		etf.Tuple{0.0, 0.0, 1.0},
+		etf.Tuple{0.0, 0.0},
+		etf.Atom("point"),
+	}
+	for _, term := range points {
+		testUnmarshal(t, term, new(Point), new(plainPoint))
+	}
+
+	options := []etf.Term{
+		mapOf(),
+		mapOf(
+			etf.Atom("level"), etf.Atom("debug"),
+			etf.Atom("limit"), 10,
+			etf.Atom("chunks"), etf.List{[]byte{1}, "ab", etf.List{}},
+			etf.Atom("grid"), etf.List{etf.List{1, 2}, "ab", etf.List{}},
+			etf.Atom("Debug"), true,
+			etf.Atom("flags"), etf.List{etf.Atom("a")},
+			etf.Atom("other"), 1,
+		),
+		mapOf([]byte("level"), etf.Atom("info"), "limit", 1, 1, 2),
+		mapOf(etf.Atom("limit"), 1, []byte("limit"), 2),
+		mapOf(etf.Atom("limit"), -1, []byte("level"), 2),
+		mapOf(etf.Atom("limit"), new(big.Int).Lsh(big.NewInt(1), 64)),
+		mapOf(etf.Atom("limit"), new(big.Int).Lsh(big.NewInt(1), 63)),
+		mapOf(etf.Atom("level"), "info", etf.Atom("Debug"), etf.Atom("yes")),
+		mapOf(etf.Atom("grid"), etf.List{etf.List{1.5}}),
+		mapOf(etf.Atom("flags"), etf.List{"a"}),
+		etf.Tuple{},
+	}
+	for _, term := range options {
+		testUnmarshal(t, term, new(Options), new(plainOptions))
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := testPerson
+	want.Nick, want.Emails = "ヨンス", []string{"jöns@example.com"}
+	b, err := etf.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Person
+	if err = etf.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+// mapOf returns the map of the keys and values in kv.
+func mapOf(kv ...etf.Term) etf.Map {
+	m := make(etf.Map, 0, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		m = append(m, etf.MapElem{Key: kv[i], Value: kv[i+1]})
+	}
+	return m
+}
+
+// testUnmarshal checks that gen, which has generated methods, decodes
+// the encoding of term as plain, of the same type but without them.
+func testUnmarshal(t *testing.T, term etf.Term, gen, plain interface{}) {
+	t.Helper()
+	b, err := etf.Marshal(term)
+	if err != nil {
+		t.Fatalf("%v: %v", term, err)
+	}
+
+	wantErr := etf.Unmarshal(b, plain)
+	err = etf.Unmarshal(b, gen)
+	// the errors name the type decoded into
+	want := strings.Replace(fmt.Sprint(wantErr), "example.plain", "example.", -1)
+	if fmt.Sprint(err) != want {
+		t.Errorf("%v into %T: got error %v, want %v", term, gen, err, want)
+	} else if err != nil {
+		return
+	}
+
+	got := reflect.ValueOf(gen).Elem().Convert(reflect.TypeOf(plain).Elem()).Interface()
+	if want := reflect.ValueOf(plain).Elem().Interface(); !reflect.DeepEqual(got, want) {
+		t.Errorf("%v into %T: got %#v, want %#v", term, gen, got, want)
+	}
+}
+
+func TestMarshalAllocs(t *testing.T) {
+	// Person has fields left to AppendTerm, which allocates.
+	a := testPerson.Home
+	b := make([]byte, 0, 256)
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := a.appendETF(b[:0]); err != nil {
+			t.Fatal(err)
+		} else if _, err = testOptions.appendETF(b[:0]); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocs, want 0", allocs)
+	}
+}
+
+func BenchmarkMarshalPerson(b *testing.B) {
+	b.Run("Generated", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := etf.Marshal(testPerson); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Reflect", func(b *testing.B) {
+		p := plainPerson(testPerson)
+		for i := 0; i < b.N; i++ {
+			if _, err := etf.Marshal(p); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkUnmarshalPerson(b *testing.B) {
+	data, err := etf.Marshal(testPerson)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("Generated", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var p Person
+			if err := etf.Unmarshal(data, &p); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Reflect", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var p plainPerson
+			if err := etf.Unmarshal(data, &p); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,73 @@
+// Etfgen generates MarshalETF and UnmarshalETF methods for Go struct
+// types, which encode and decode them as etf.Marshal and etf.Unmarshal
+// would, following their etf field tags, but without going through
+// reflection for the structs themselves.
+//
+// Usage:
+//
+//	etfgen -type T[,T...] [-output file] [dir]
+//
+// It is meant to be run by go generate, from a comment such as
+//
+//	//go:generate etfgen -type Person,Address
+//
+// in the package that declares the types, which must be structs. The
+// methods are written to t_etf.go in the package directory, dir or ".",
+// where t is the first type in lower case, unless -output says where.
+//
+// Fields of the listed types, of predeclared types, etf.Atom and []byte
+// and slices of these are encoded and decoded by the generated code.
+// Other fields, and values of unexpected terms, go through AppendTerm
+// and DecodeTerm, so that the results are always those of the
+// reflective encoding. MarshalETF has a value receiver, so that values
+// as well as pointers of the types are etf.Marshalers.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of struct `types`; must be set")
+	output    = flag.String("output", "", "output `file`; default dir/<type>_etf.go")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: etfgen -type T[,T...] [-output file] [dir]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("etfgen: ")
+	flag.Usage = usage
+	flag.Parse()
+	if *typeNames == "" || flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dir := "."
+	if flag.NArg() == 1 {
+		dir = flag.Arg(0)
+	}
+	types := strings.Split(*typeNames, ",")
+
+	src, err := generate(dir, types)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	name := *output
+	if name == "" {
+		name = filepath.Join(dir, strings.ToLower(types[0])+"_etf.go")
+	}
+	if err = os.WriteFile(name, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,339 @@
+package etf
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+)
+
+// ErrTypeMismatch is returned when a term can't be stored in a Go value
+// of the requested type.
+type ErrTypeMismatch struct {
+	term Term
+	t    reflect.Type
+}
+
+func (e *ErrTypeMismatch) Error() string {
+	return fmt.Sprintf("decode: can't store %T into %s", e.term, e.t)
+}
+
+// Unmarshaler is implemented by types that decode themselves.
+// UnmarshalETF gets the encoding of a single term, without the version
+// byte. Unless it comes straight from Unmarshal, that is the term as
+// Write encodes it, not necessarily the exact bytes received.
+type Unmarshaler interface {
+	UnmarshalETF([]byte) error
+}
+
+// TermUnmarshaler is implemented by types that decode themselves from
+// the term returned by Read.
+type TermUnmarshaler interface {
+	UnmarshalTerm(Term) error
+}
+
+// DecodeTerm stores term, as returned by Read, in the value pointed to
+// by v, converting it to the type of that value:
+//
+//   - tuples decode into structs, one exported field per element,
+//     following their etf field tags (see Marshal),
+//   - lists decode into slices and arrays,
+//   - binaries decode into []byte or string,
+//   - strings decode into string, or into slices of their characters;
+//     a Go string term is taken as the bytes it holds, as Write takes
+//     it, so that Unmarshal undoes Marshal, and text read as Latin-1
+//     is converted to UTF-8 only if Context.Charlists says so,
+//   - atoms decode into string-kinded types such as Atom,
+//   - integers decode into any sized integer, failing on overflow,
+//   - maps decode into Go maps,
+//   - anything decodes into an empty interface,
+//   - types implementing Unmarshaler or TermUnmarshaler decode themselves.
+func DecodeTerm(term Term, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("decode: can't store into %T", v)
+	}
+	return decode(term, rv.Elem())
+}
+
+func decode(term Term, v reflect.Value) (err error) {
+	if v.CanAddr() {
+		switch u := v.Addr().Interface().(type) {
+		case Unmarshaler:
+			var b []byte
+			if b, err = new(Context).appendTerm(nil, term); err != nil {
+				return
+			}
+			return u.UnmarshalETF(b)
+		case TermUnmarshaler:
+			return u.UnmarshalTerm(term)
+		}
+	}
+
+	if tv := reflect.ValueOf(term); tv.IsValid() && tv.Type().AssignableTo(v.Type()) {
+		v.Set(tv)
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, ok := term.(bool)
+		if !ok {
+			return &ErrTypeMismatch{term, v.Type()}
+		}
+		v.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, ok := integer(term)
+		if !ok {
+			return &ErrTypeMismatch{term, v.Type()}
+		} else if !x.IsInt64() || v.OverflowInt(x.Int64()) {
+			return fmt.Errorf("decode: %v overflows %s", x, v.Type())
+		}
+		v.SetInt(x.Int64())
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		x, ok := integer(term)
+		if !ok {
+			return &ErrTypeMismatch{term, v.Type()}
+		} else if x.Sign() < 0 || !x.IsUint64() || v.OverflowUint(x.Uint64()) {
+			return fmt.Errorf("decode: %v overflows %s", x, v.Type())
+		}
+		v.SetUint(x.Uint64())
+
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if x, ok := integer(term); ok {
+			f, _ = new(big.Float).SetInt(x).Float64()
+		} else if f, ok = term.(float64); !ok {
+			return &ErrTypeMismatch{term, v.Type()}
+		}
+		if v.OverflowFloat(f) {
+			return fmt.Errorf("decode: %v overflows %s", f, v.Type())
+		}
+		v.SetFloat(f)
+
+	case reflect.String:
+		var s string
+		switch t := term.(type) {
+		case Atom:
+			s = string(t)
+		case bool:
+			s = atomText(t)
+		case string:
+			s = t
+		case []byte:
+			s = string(t)
+		case Charlist:
+			s = string(t)
+		case List:
+			runes := make([]rune, len(t))
+			for i := range t {
+				if c, ok := t[i].(int); ok {
+					runes[i] = rune(c)
+				} else {
+					return &ErrTypeMismatch{term, v.Type()}
+				}
+			}
+			s = string(runes)
+		default:
+			return &ErrTypeMismatch{term, v.Type()}
+		}
+		v.SetString(s)
+
+	case reflect.Slice:
+		err = decodeSlice(term, v)
+
+	case reflect.Array:
+		err = decodeArray(term, v)
+
+	case reflect.Struct:
+		err = decodeStruct(term, v)
+
+	case reflect.Map:
+		err = decodeMap(term, v)
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		err = decode(term, v.Elem())
+
+	default:
+		err = &ErrTypeMismatch{term, v.Type()}
+	}
+
+	return
+}
+
+// integer returns term as a big integer if it is an integer.
+func integer(term Term) (*big.Int, bool) {
+	switch t := term.(type) {
+	case int:
+		return big.NewInt(int64(t)), true
+	case int64:
+		return big.NewInt(t), true
+	case *big.Int:
+		return t, true
+	}
+	return nil, false
+}
+
+// elements returns the elements of a list term, reading STRING_EXT
+// strings as the lists of bytes they are.
+func elements(term Term) ([]Term, bool) {
+	switch t := term.(type) {
+	case List:
+		return t, true
+	case string:
+		l := make([]Term, len(t))
+		for i := 0; i < len(t); i++ {
+			l[i] = int(t[i])
+		}
+		return l, true
+	case Charlist:
+		l := make([]Term, len(t))
+		for i := range t {
+			l[i] = int(t[i])
+		}
+		return l, true
+	}
+	return nil, false
+}
+
+func decodeSlice(term Term, v reflect.Value) (err error) {
+	if v.Type().Elem().Kind() == reflect.Uint8 {
+		switch t := term.(type) {
+		case []byte:
+			v.SetBytes(append([]byte(nil), t...))
+			return
+		case string:
+			v.SetBytes([]byte(t))
+			return
+		}
+	}
+
+	l, ok := elements(term)
+	if !ok {
+		return &ErrTypeMismatch{term, v.Type()}
+	}
+
+	s := reflect.MakeSlice(v.Type(), len(l), len(l))
+	for i := range l {
+		if err = decode(l[i], s.Index(i)); err != nil {
+			return
+		}
+	}
+	v.Set(s)
+
+	return
+}
+
+func decodeArray(term Term, v reflect.Value) (err error) {
+	var l []Term
+	if b, ok := term.([]byte); ok && v.Type().Elem().Kind() == reflect.Uint8 {
+		l = make([]Term, len(b))
+		for i := range b {
+			l[i] = int(b[i])
+		}
+	} else if l, ok = elements(term); !ok {
+		return &ErrTypeMismatch{term, v.Type()}
+	}
+
+	if len(l) != v.Len() {
+		return fmt.Errorf("decode: can't store %d elements into %s", len(l), v.Type())
+	}
+	for i := range l {
+		if err = decode(l[i], v.Index(i)); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func decodeStruct(term Term, v reflect.Value) (err error) {
+	si := getStructInfo(v.Type())
+	if si.asMap {
+		return decodeStructMap(si, term, v)
+	}
+
+	tuple, ok := term.(Tuple)
+	if !ok {
+		return &ErrTypeMismatch{term, v.Type()}
+	}
+
+	if si.hasTag {
+		if len(tuple) == 0 || tuple[0] != si.tag {
+			return fmt.Errorf("decode: can't store %v into %s, expected a %s record",
+				tuple, v.Type(), si.tag)
+		}
+		tuple = tuple[1:]
+	}
+
+	if len(tuple) != len(si.fields) {
+		return fmt.Errorf("decode: can't store tuple of %d into %s", len(tuple), v.Type())
+	}
+	for i, f := range si.fields {
+		if err = decode(tuple[i], v.Field(f.index)); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// decodeStructMap stores the values of a map in the struct fields
+// named by their keys. Keys may be atoms, binaries or strings; fields
+// missing from the map are left alone.
+func decodeStructMap(si *structInfo, term Term, v reflect.Value) (err error) {
+	m, ok := term.(Map)
+	if !ok {
+		return &ErrTypeMismatch{term, v.Type()}
+	}
+
+	values := make(map[string]Term, len(m))
+	for _, e := range m {
+		switch k := e.Key.(type) {
+		case Atom:
+			values[string(k)] = e.Value
+		case []byte:
+			values[string(k)] = e.Value
+		case string:
+			values[k] = e.Value
+		}
+	}
+
+	for _, f := range si.fields {
+		if value, ok := values[string(f.key)]; ok {
+			if err = decode(value, v.Field(f.index)); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+func decodeMap(term Term, v reflect.Value) (err error) {
+	m, ok := term.(Map)
+	if !ok {
+		return &ErrTypeMismatch{term, v.Type()}
+	}
+
+	t := v.Type()
+	gm := reflect.MakeMapWithSize(t, len(m))
+	for _, e := range m {
+		key := reflect.New(t.Key()).Elem()
+		value := reflect.New(t.Elem()).Elem()
+		if err = decode(e.Key, key); err != nil {
+			return
+		} else if err = decode(e.Value, value); err != nil {
+			return
+		}
+		gm.SetMapIndex(key, value)
+	}
+	v.Set(gm)
+
+	return
+}
@@ -0,0 +1,228 @@
+package etf
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testUUID encodes itself as a binary of 16 bytes.
+type testUUID [16]byte
+
+func (u testUUID) MarshalETF() ([]byte, error) {
+	return append([]byte{ettBinary, 0, 0, 0, 16}, u[:]...), nil
+}
+
+func (u *testUUID) UnmarshalETF(b []byte) error {
+	if len(b) != 21 || bytes.Compare(b[:5], []byte{ettBinary, 0, 0, 0, 16}) != 0 {
+		return fmt.Errorf("bad uuid %v", b)
+	}
+	copy(u[:], b[5:])
+	return nil
+}
+
+// testTime is encoded as an erlang:timestamp() tuple.
+type testTime struct {
+	time.Time
+}
+
+func (t testTime) MarshalTerm() (Term, error) {
+	us := t.UnixNano() / 1000
+	return Tuple{us / 1e12, us / 1e6 % 1e6, us % 1e6}, nil
+}
+
+func (t *testTime) UnmarshalTerm(term Term) error {
+	var ts struct{ Mega, Sec, Micro int64 }
+	if err := DecodeTerm(term, &ts); err != nil {
+		return err
+	}
+	t.Time = time.Unix(ts.Mega*1e6+ts.Sec, ts.Micro*1000)
+	return nil
+}
+
+func TestDecodeTerm(t *testing.T) {
+	type point struct {
+		X, Y int16
+		z    int
+	}
+	type shape struct {
+		Name   Atom
+		Label  string
+		Points []point
+		Data   []byte
+		Scale  float32
+		Tags   map[Atom]uint8
+		Center *point
+		Extra  interface{}
+		Ok     bool
+	}
+
+	in := Tuple{
+		Atom("square"),
+		[]byte("a square"),
+		List{Tuple{0, 0}, Tuple{-1, 300}},
+		"raw",
+		1.5,
+		Map{{Atom("a"), 1}, {Atom("b"), 255}},
+		Tuple{7, 8},
+		Tuple{1, 2},
+		true,
+	}
+	exp := shape{
+		Name:   Atom("square"),
+		Label:  "a square",
+		Points: []point{{0, 0, 0}, {-1, 300, 0}},
+		Data:   []byte("raw"),
+		Scale:  1.5,
+		Tags:   map[Atom]uint8{"a": 1, "b": 255},
+		Center: &point{7, 8, 0},
+		Extra:  Tuple{1, 2},
+		Ok:     true,
+	}
+
+	var v shape
+	if err := DecodeTerm(in, &v); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(v, exp) {
+		t.Errorf("expected %#v, got %#v", exp, v)
+	}
+
+	// error (tuple too short for the nested struct)
+	in[6] = Tuple{7}
+	if err := DecodeTerm(in, &v); err == nil {
+		t.Error("err == nil")
+	}
+
+	// error (tuple element of the wrong type)
+	in[6] = Tuple{7, Atom("eight")}
+	if err := DecodeTerm(in, &v); err == nil {
+		t.Error("err == nil")
+	}
+}
+
+func TestDecodeScalars(t *testing.T) {
+	test := func(in Term, ptr interface{}, exp interface{}, shouldFail bool) {
+		err := DecodeTerm(in, ptr)
+		if err != nil {
+			if !shouldFail {
+				t.Errorf("%v into %T: %v", in, ptr, err)
+			}
+		} else if shouldFail {
+			t.Errorf("%v into %T: err == nil", in, ptr)
+		} else if v := reflect.ValueOf(ptr).Elem().Interface(); !reflect.DeepEqual(v, exp) {
+			t.Errorf("expected %#v, got %#v", exp, v)
+		}
+	}
+
+	type name string
+
+	test(255, new(uint8), uint8(255), false)
+	test(256, new(uint8), nil, true)
+	test(-1, new(uint), nil, true)
+	test(-128, new(int8), int8(-128), false)
+	test(-129, new(int8), nil, true)
+	test(int64(math.MaxInt64), new(int64), int64(math.MaxInt64), false)
+	test(new(big.Int).Lsh(big.NewInt(1), 64), new(uint64), nil, true)
+	test(new(big.Int).SetUint64(math.MaxUint64), new(uint64), uint64(math.MaxUint64), false)
+	test(1.5, new(int), nil, true)
+	test(2, new(float64), 2.0, false)
+	test(Atom("ok"), new(name), name("ok"), false)
+	test(true, new(string), "true", false)
+	test([]byte("bin"), new(string), "bin", false)
+	test("abc", new(string), "abc", false)
+	test(List{104, 233}, new(string), "hé", false)
+	test(Charlist{104, 233}, new(string), "hé", false)
+	test("h\xc3\xa9", new(string), "hé", false)
+	test("h\xe9", new(string), "h\xe9", false)
+	test(Charlist{104, 233}, new([]int), []int{104, 233}, false)
+	test(List{Atom("a")}, new(string), nil, true)
+	test("abc", new([]byte), []byte("abc"), false)
+	test("abc", new([]int), []int{97, 98, 99}, false)
+	test([]byte{1, 2}, new([2]byte), [2]byte{1, 2}, false)
+	test([]byte{1, 2}, new([3]byte), nil, true)
+	test(List{Atom("a"), Atom("b")}, new([]Atom), []Atom{"a", "b"}, false)
+	test(Atom("a"), new(bool), nil, true)
+	test(Atom("a"), new(int), nil, true)
+	test(Tuple{1}, new(struct{ A, B int }), nil, true)
+	test(List{}, new(map[string]int), nil, true)
+	test(Map{{[]byte("k"), 1}}, new(map[string]int), map[string]int{"k": 1}, false)
+
+	if err := DecodeTerm(1, 1); err == nil {
+		t.Error("err == nil")
+	}
+
+	for _, in := range []string{"été", "日本", "h\xe9", ""} {
+		b, err := Marshal(in)
+		if err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		var s string
+		if err = Unmarshal(b, &s); err != nil {
+			t.Errorf("%q: %v", in, err)
+		} else if s != in {
+			t.Errorf("%q: expected it back, got %q", in, s)
+		}
+	}
+}
+
+func TestUnmarshalStruct(t *testing.T) {
+	type person struct {
+		Name string
+		Age  int
+	}
+
+	w := bytes.NewBuffer([]byte{EtVersion})
+	if err := new(Context).Write(w, person{"Joe", 71}); err != nil {
+		t.Fatal(err)
+	}
+
+	var p person
+	if err := Unmarshal(w.Bytes(), &p); err != nil {
+		t.Error(err)
+	} else if exp := (person{"Joe", 71}); p != exp {
+		t.Errorf("expected %v, got %v", exp, p)
+	}
+}
+
+func TestUnmarshaler(t *testing.T) {
+	type event struct {
+		Id   testUUID
+		At   testTime
+		Refs []testUUID
+	}
+
+	id := testUUID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	in := event{id, testTime{time.Unix(1500000000, 123456000)}, []testUUID{id}}
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v event
+	if err = Unmarshal(b, &v); err != nil {
+		t.Error(err)
+	} else if v.Id != in.Id || !v.At.Equal(in.At.Time) || len(v.Refs) != 1 || v.Refs[0] != id {
+		t.Errorf("expected %v, got %v", in, v)
+	}
+
+	// straight from Unmarshal
+	var u testUUID
+	if err = Unmarshal(append([]byte{131, 109, 0, 0, 0, 16}, id[:]...), &u); err != nil {
+		t.Error(err)
+	} else if u != id {
+		t.Errorf("expected %v, got %v", id, u)
+	}
+
+	// errors are passed on
+	if err = DecodeTerm([]byte{1}, &u); err == nil {
+		t.Error("err == nil")
+	}
+	var ts testTime
+	if err = DecodeTerm(Tuple{1, 2}, &ts); err == nil {
+		t.Error("err == nil")
+	}
+}
@@ -1,3 +1,5 @@
+// Package etf implements encoding and decoding of the Erlang external
+// term format.
 package etf
 
 import (
@@ -15,10 +17,86 @@ type atomCacheRef struct {
 }
 
 type Context struct {
-	atomCache    [2048]*string
+	atomCache    *[atomCacheSize]*string
 	currentCache []*string
+	out          *outCache
+
+	// Strings selects how Write encodes Go strings.
+	Strings StringFormat
+
+	// MapKeys selects how string keys of Go maps are encoded.
+	// StringDefault means as Strings says.
+	MapKeys StringFormat
+
+	// Charlists selects what Read returns for strings, whether
+	// encoded as STRING_EXT or as lists of characters.
+	Charlists CharlistMode
+
+	// UnicodeCharlists makes Read take lists of any Unicode code
+	// points for strings, rather than only lists of Latin-1 characters.
+	UnicodeCharlists bool
+
+	// Compression, if not zero, is the zlib level (see compress/zlib)
+	// at which Write compresses the terms it encodes.
+	Compression int
+
+	// CompressThreshold is the encoded size in bytes below which
+	// Write leaves terms uncompressed.
+	CompressThreshold int
+
+	// TargetOTP is the oldest OTP release that must be able to decode
+	// what Write produces. Zero means the current release.
+	TargetOTP int
+
+	// Limits bound the terms Read accepts.
+	Limits Limits
+
+	// Atoms, if set, is where Read, ReadBytes and ReadDist intern the
+	// atoms they decode, which then never share memory with the input.
+	Atoms *AtomTable
+
+	// UnsafeStrings makes ReadBytes return atoms and strings that
+	// share memory with its input rather than copies of it. The input
+	// must then never change while they are in use, since Go strings
+	// can't change.
+	UnsafeStrings bool
 }
 
+// StringFormat selects the external representation of a Go string.
+type StringFormat int
+
+const (
+	// StringDefault encodes strings the way Write does by default, as
+	// STRING_EXT holding their bytes.
+	StringDefault StringFormat = iota
+	// StringCharlist encodes strings as lists of their characters, the
+	// way Erlang encodes them: as STRING_EXT if they are short enough
+	// and all Latin-1, as LIST_EXT otherwise.
+	StringCharlist
+	// StringBinary encodes strings as BINARY_EXT.
+	StringBinary
+	// StringAtom encodes strings as atoms.
+	StringAtom
+	// StringList encodes strings as LIST_EXT of their characters.
+	StringList
+)
+
+// CharlistMode selects what Read returns for strings.
+type CharlistMode int
+
+const (
+	// CharlistsRaw returns STRING_EXT as a Go string holding its bytes,
+	// and lists of characters as the List they are.
+	CharlistsRaw CharlistMode = iota
+	// CharlistsAsCharlist returns strings as Charlist.
+	CharlistsAsCharlist
+	// CharlistsAsString returns strings as Go strings, in UTF-8.
+	CharlistsAsString
+)
+
+// Charlist is an Erlang string, a list of character codes.
+type Charlist []rune
+
 type Term interface{}
 type Tuple []Term
 type List []Term
@@ -28,22 +106,27 @@ type Pid struct {
 	Node     Atom
 	Id       uint32
 	Serial   uint32
-	Creation byte
+	Creation uint32
 }
 
 type Port struct {
 	Node     Atom
-	Id       uint32
-	Creation byte
+	Id       uint64
+	Creation uint32
 }
 
 type Ref struct {
 	Node     Atom
-	Creation byte
+	Creation uint32
 	Id       []uint32
 }
 
+// Function is a fun, as encoded by NEW_FUN_EXT or the older FUN_EXT
+// which lacks Size, Arity, Unique and Index. Write computes Size and
+// Free itself, from the rest of the fields, and writes FUN_EXT when
+// Arity, Unique and Index are all zero, as they are when read from it.
 type Function struct {
+	Size      uint32
 	Arity     byte
 	Unique    [16]byte
 	Index     uint32
@@ -55,6 +138,27 @@ type Function struct {
 	FreeVars  []Term
 }
 
+// isOld reports whether f is encoded as FUN_EXT.
+func (f *Function) isOld() bool {
+	return f.Arity == 0 && f.Unique == [16]byte{} && f.Index == 0
+}
+
+// ImproperList is a list whose tail is not the empty list, such as
+// [a, b | c] or the iolist [<<"a">> | <<"b">>].
+type ImproperList struct {
+	Elems List
+	Tail  Term
+}
+
+// Map is an Erlang map. Keys may be any term, so the pairs are kept
+// in a slice, in the order they were received or are to be sent.
+type Map []MapElem
+
+type MapElem struct {
+	Key   Term
+	Value Term
+}
+
 type Export struct {
 	Module   Atom
 	Function Atom
@@ -69,6 +173,7 @@ const (
 	ettBitBinary     = 'M'
 	ettCachedAtom    = 'C'
 	ettCacheRef      = 'R'
+	ettCompressed    = 'P'
 	ettExport        = 'q'
 	ettFloat         = 'c'
 	ettFun           = 'u'
@@ -76,10 +181,14 @@ const (
 	ettLargeBig      = 'o'
 	ettLargeTuple    = 'i'
 	ettList          = 'l'
+	ettMap           = 't'
 	ettNewCache      = 'N'
 	ettNewFloat      = 'F'
 	ettNewFun        = 'p'
+	ettNewPid        = 'X'
+	ettNewPort       = 'Y'
 	ettNewRef        = 'r'
+	ettNewerRef      = 'Z'
 	ettNil           = 'j'
 	ettPid           = 'g'
 	ettPort          = 'f'
@@ -90,6 +199,7 @@ const (
 	ettSmallInteger  = 'a'
 	ettSmallTuple    = 'h'
 	ettString        = 'k'
+	ettV4Port        = 'x'
 )
 
 const (
@@ -108,6 +218,7 @@ var tagNames = map[byte]string{
 	ettBinary:        "BINARY_EXT",
 	ettBitBinary:     "BIT_BINARY_EXT",
 	ettCachedAtom:    "ATOM_CACHE_REF",
+	ettCompressed:    "COMPRESSED",
 	ettExport:        "EXPORT_EXT",
 	ettFloat:         "FLOAT_EXT",
 	ettFun:           "FUN_EXT",
@@ -115,10 +226,14 @@ var tagNames = map[byte]string{
 	ettLargeBig:      "LARGE_BIG_EXT",
 	ettLargeTuple:    "LARGE_TUPLE_EXT",
 	ettList:          "LIST_EXT",
+	ettMap:           "MAP_EXT",
 	ettNewCache:      "NEW_CACHE_EXT",
 	ettNewFloat:      "NEW_FLOAT_EXT",
 	ettNewFun:        "NEW_FUN_EXT",
+	ettNewPid:        "NEW_PID_EXT",
+	ettNewPort:       "NEW_PORT_EXT",
 	ettNewRef:        "NEW_REFERENCE_EXT",
+	ettNewerRef:      "NEWER_REFERENCE_EXT",
 	ettNil:           "NIL_EXT",
 	ettPid:           "PID_EXT",
 	ettPort:          "PORT_EXT",
@@ -129,6 +244,7 @@ var tagNames = map[byte]string{
 	ettSmallInteger:  "SMALL_INTEGER_EXT",
 	ettSmallTuple:    "SMALL_TUPLE_EXT",
 	ettString:        "STRING_EXT",
+	ettV4Port:        "V4_PORT_EXT",
 }
 
 func (t Tuple) Element(i int) Term {
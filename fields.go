@@ -0,0 +1,117 @@
+package etf
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// structInfo describes how a struct type is encoded, as set by the
+// etf tags of its fields.
+type structInfo struct {
+	tag    Atom
+	hasTag bool
+	asMap  bool
+	fields []fieldInfo // in key order if asMap
+}
+
+type fieldInfo struct {
+	index  int
+	key    Atom
+	format StringFormat
+}
+
+var structInfos sync.Map // reflect.Type → *structInfo
+
+func getStructInfo(t reflect.Type) *structInfo {
+	if si, ok := structInfos.Load(t); ok {
+		return si.(*structInfo)
+	}
+
+	si := new(structInfo)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, opts := parseTag(sf.Tag.Get("etf"))
+
+		if sf.Name == "_" {
+			if name != "" {
+				si.tag, si.hasTag = Atom(name), true
+			}
+			si.asMap = opts["map"]
+			continue
+		} else if sf.PkgPath != "" || name == "-" {
+			// unexported or omitted
+			continue
+		}
+
+		f := fieldInfo{index: i, key: Atom(sf.Name)}
+		if name != "" {
+			f.key = Atom(name)
+		}
+		switch {
+		case opts["atom"]:
+			f.format = StringAtom
+		case opts["binary"]:
+			f.format = StringBinary
+		case opts["charlist"]:
+			f.format = StringCharlist
+		}
+		si.fields = append(si.fields, f)
+	}
+
+	if si.asMap {
+		// in the order the map is encoded in
+		sort.SliceStable(si.fields, func(i, j int) bool {
+			return compareTerms(si.fields[i].key, si.fields[j].key) < 0
+		})
+	}
+
+	actual, _ := structInfos.LoadOrStore(t, si)
+	return actual.(*structInfo)
+}
+
+func parseTag(tag string) (name string, opts map[string]bool) {
+	parts := strings.Split(tag, ",")
+	opts = make(map[string]bool, len(parts)-1)
+	for _, o := range parts[1:] {
+		opts[o] = true
+	}
+	return parts[0], opts
+}
+
+// term returns the tuple or map that the struct rv is encoded as.
+func (si *structInfo) term(rv reflect.Value) Term {
+	if si.asMap {
+		m := make(Map, len(si.fields))
+		for i, f := range si.fields {
+			m[i] = MapElem{f.key, f.value(rv)}
+		}
+		return m
+	}
+
+	var t Tuple
+	if si.hasTag {
+		t = make(Tuple, 1, len(si.fields)+1)
+		t[0] = si.tag
+	} else {
+		t = make(Tuple, 0, len(si.fields))
+	}
+	for _, f := range si.fields {
+		t = append(t, f.value(rv))
+	}
+	return t
+}
+
+// value returns the field of rv, converted as the field's options say.
+func (f *fieldInfo) value(rv reflect.Value) Term {
+	v := rv.Field(f.index)
+	if v.Kind() != reflect.String {
+		return v.Interface()
+	}
+
+	if f.format == StringDefault {
+		return v.Interface()
+	}
+	return stringTerm(v.String(), f.format)
+}
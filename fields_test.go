@@ -0,0 +1,81 @@
+package etf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestStructTags(t *testing.T) {
+	type person struct {
+		_    struct{} `etf:"person"`
+		Name string   `etf:"name,binary"`
+		Age  int      `etf:"age"`
+		Temp []byte   `etf:"-"`
+		Role string   `etf:",atom"`
+		Nick string   `etf:",charlist"`
+		note string
+	}
+	type point struct {
+		_ struct{} `etf:",map"`
+		X int      `etf:"x"`
+		Y int
+	}
+
+	test := func(in, out interface{}, exp []byte) {
+		b, err := Marshal(in)
+		if err != nil {
+			t.Fatal(in, err)
+		} else if bytes.Compare(b, exp) != 0 {
+			t.Errorf("expected %v, got %v", exp, b)
+		} else if err = Unmarshal(b, out); err != nil {
+			t.Error(in, err)
+		}
+	}
+
+	p := person{Name: "Joe", Age: 71, Temp: []byte{1}, Role: "admin", Nick: "j", note: "x"}
+	var p1 person
+	test(p, &p1, []byte{
+		131, 104, 5,
+		119, 6, 'p', 'e', 'r', 's', 'o', 'n',
+		109, 0, 0, 0, 3, 'J', 'o', 'e',
+		97, 71,
+		119, 5, 'a', 'd', 'm', 'i', 'n',
+		107, 0, 1, 'j',
+	})
+	p.Temp, p.note = nil, ""
+	if !reflect.DeepEqual(p1, p) {
+		t.Errorf("expected %v, got %v", p, p1)
+	}
+
+	var pt point
+	test(point{X: 1, Y: 2}, &pt, []byte{
+		131, 116, 0, 0, 0, 2,
+		119, 1, 'Y', 97, 2,
+		119, 1, 'x', 97, 1,
+	})
+	if exp := (point{X: 1, Y: 2}); pt != exp {
+		t.Errorf("expected %v, got %v", exp, pt)
+	}
+
+	// maps with missing, extra and binary keys
+	pt = point{}
+	in := Map{{[]byte("x"), 3}, {Atom("z"), 4}}
+	if err := DecodeTerm(in, &pt); err != nil {
+		t.Error(err)
+	} else if exp := (point{X: 3}); pt != exp {
+		t.Errorf("expected %v, got %v", exp, pt)
+	}
+
+	// records with the wrong tag
+	in2 := Tuple{Atom("animal"), []byte("Rex"), 3, Atom("dog"), "r"}
+	if err := DecodeTerm(in2, &p1); err == nil {
+		t.Error("err == nil")
+	}
+	in2[0] = Atom("person")
+	if err := DecodeTerm(in2, &p1); err != nil {
+		t.Error(err)
+	} else if exp := (person{Name: "Rex", Age: 3, Role: "dog", Nick: "r"}); !reflect.DeepEqual(p1, exp) {
+		t.Errorf("expected %v, got %v", exp, p1)
+	}
+}
@@ -0,0 +1,257 @@
+package etf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// Limits bound what Read accepts, to defend against hostile or
+// corrupted input. Zero fields mean no limit.
+type Limits struct {
+	// MaxBytes bounds the encoded size of a term. For compressed
+	// terms, the uncompressed size counts.
+	MaxBytes int64
+	// MaxDepth bounds how deeply terms nest, a term on its own
+	// being at depth 1.
+	MaxDepth int
+	// MaxElements bounds the elements of a tuple, list or map, the
+	// free variables of a fun and the id words of a reference.
+	MaxElements int
+	// MaxBinary bounds the size in bytes of a binary or bitstring.
+	MaxBinary int
+	// MaxBigInt bounds the size in bytes of a big integer.
+	MaxBigInt int
+}
+
+// ErrLimit is returned by Read when a term exceeds one of the Limits.
+type ErrLimit struct {
+	// Limit is the name of the Limits field that was exceeded.
+	Limit string
+	// Size is the size that exceeded it.
+	Size int64
+}
+
+func (e *ErrLimit) Error() string {
+	return fmt.Sprintf("read: %s exceeded (%d)", e.Limit, e.Size)
+}
+
+// Sizes read from the wire are only trusted up to allocChunk;
+// beyond that memory is allocated as the data actually arrives.
+const allocChunk = 4096
+
+// reader wraps the input of a single Read, keeping count of the bytes
+// read and of the nesting depth to enforce the limits, and of the path
+// to the term being read to report errors. Without r, it reads from buf
+// instead, handing out slices of it rather than copies.
+type reader struct {
+	r      io.Reader
+	br     io.ByteReader
+	buf    []byte
+	alias  bool // strings may share memory with buf
+	limits *Limits
+	n      int64
+	depth  int
+	path   []step
+	b      [8]byte
+}
+
+// step is the internal form of a PathElem.
+type step struct {
+	tag   byte
+	index int
+}
+
+func newReader(r io.Reader, limits *Limits) *reader {
+	rd := &reader{r: r, limits: limits}
+	rd.br, _ = r.(io.ByteReader)
+	return rd
+}
+
+// newBufReader returns a reader of b, which is assumed not to change
+// while the terms read from it are in use if alias is set.
+func newBufReader(b []byte, limits *Limits, alias bool) *reader {
+	return &reader{buf: b, alias: alias, limits: limits}
+}
+
+func (r *reader) Read(p []byte) (n int, err error) {
+	if max := r.limits.MaxBytes; max > 0 && r.n+int64(len(p)) > max {
+		if r.n >= max {
+			return 0, &ErrLimit{"MaxBytes", r.n + int64(len(p))}
+		}
+		p = p[:max-r.n]
+	}
+	if r.r == nil {
+		if n = copy(p, r.buf[r.n:]); n == 0 && len(p) > 0 {
+			err = io.EOF
+		}
+	} else {
+		n, err = r.r.Read(p)
+	}
+	r.n += int64(n)
+	return
+}
+
+// ReadByte reads exactly one byte from the underlying reader, which
+// keeps zlib from reading ahead of compressed data.
+func (r *reader) ReadByte() (b byte, err error) {
+	if max := r.limits.MaxBytes; max > 0 && r.n >= max {
+		return 0, &ErrLimit{"MaxBytes", r.n + 1}
+	}
+	if r.r == nil {
+		if r.n >= int64(len(r.buf)) {
+			return 0, io.EOF
+		}
+		b = r.buf[r.n]
+	} else if r.br != nil {
+		b, err = r.br.ReadByte()
+	} else {
+		_, err = io.ReadFull(r.r, r.b[:1])
+		b = r.b[0]
+	}
+	if err == nil {
+		r.n++
+	}
+	return
+}
+
+// bytes reads n bytes. From a buffer, they are a slice of it.
+func (r *reader) bytes(n uint32) ([]byte, error) {
+	if r.r != nil {
+		return rbytes(r, n)
+	}
+
+	end := r.n + int64(n)
+	if max := r.limits.MaxBytes; max > 0 && end > max {
+		return nil, &ErrLimit{"MaxBytes", end}
+	} else if end > int64(len(r.buf)) {
+		err := io.ErrUnexpectedEOF
+		if r.n == int64(len(r.buf)) {
+			err = io.EOF
+		}
+		r.n = int64(len(r.buf))
+		return nil, err
+	}
+	b := r.buf[r.n:end:end]
+	r.n = end
+	return b, nil
+}
+
+// fixed reads n bytes, at most 8, which are only valid until the next
+// read.
+func (r *reader) fixed(n int) ([]byte, error) {
+	if r.r == nil {
+		return r.bytes(uint32(n))
+	}
+	_, err := io.ReadFull(r, r.b[:n])
+	return r.b[:n], err
+}
+
+func (r *reader) uint16() (uint16, error) {
+	b, err := r.fixed(2)
+	if err != nil {
+		return 0, err
+	}
+	return be.Uint16(b), nil
+}
+
+func (r *reader) uint32() (uint32, error) {
+	b, err := r.fixed(4)
+	if err != nil {
+		return 0, err
+	}
+	return be.Uint32(b), nil
+}
+
+func (r *reader) uint64() (uint64, error) {
+	b, err := r.fixed(8)
+	if err != nil {
+		return 0, err
+	}
+	return be.Uint64(b), nil
+}
+
+// creation reads the node creation of a pid, port or reference,
+// which is a single byte in the pre-OTP 19 encodings.
+func (r *reader) creation(legacy bool) (uint32, error) {
+	if legacy {
+		c, err := r.ReadByte()
+		return uint32(c), err
+	}
+	return r.uint32()
+}
+
+// text returns b as a string, which shares its memory if r.alias.
+func (r *reader) text(b []byte) string {
+	if r.alias && len(b) > 0 {
+		return unsafe.String(&b[0], len(b))
+	}
+	return string(b)
+}
+
+// fail wraps err, which happened decoding a term named tag, in an
+// *ErrSyntax unless it already is one.
+func (r *reader) fail(tag string, err error) error {
+	if _, ok := err.(*ErrSyntax); ok {
+		return err
+	} else if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	e := &ErrSyntax{Offset: r.n, Tag: tag, Err: err}
+	if len(r.path) > 0 {
+		e.Path = make([]PathElem, len(r.path))
+		for i, s := range r.path {
+			e.Path[i] = PathElem{tagName(s.tag), s.index}
+		}
+	}
+	return e
+}
+
+// enter descends into a nested term.
+func (r *reader) enter() error {
+	r.depth++
+	if max := r.limits.MaxDepth; max > 0 && r.depth > max {
+		return &ErrLimit{"MaxDepth", int64(r.depth)}
+	}
+	return nil
+}
+
+func (r *reader) leave() {
+	r.depth--
+}
+
+// elements checks a count of elements read from the wire and returns
+// how many of them to make room for up front.
+func (r *reader) elements(n uint32) (int, error) {
+	if max := r.limits.MaxElements; max > 0 && int64(n) > int64(max) {
+		return 0, &ErrLimit{"MaxElements", int64(n)}
+	} else if n > allocChunk {
+		return allocChunk, nil
+	}
+	return int(n), nil
+}
+
+// check returns an error if size exceeds max, unless max is zero.
+func (r *reader) check(limit string, max int, size uint32) error {
+	if max > 0 && int64(size) > int64(max) {
+		return &ErrLimit{limit, int64(size)}
+	}
+	return nil
+}
+
+// rbytes reads n bytes, growing the buffer as they arrive.
+func rbytes(r io.Reader, n uint32) ([]byte, error) {
+	if n <= allocChunk {
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		return b, err
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, allocChunk))
+	_, err := io.CopyN(buf, r, int64(n))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), err
+}
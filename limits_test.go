@@ -0,0 +1,84 @@
+package etf
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestReadLimits(t *testing.T) {
+	// [[[1]]]
+	nested := []byte{108, 0, 0, 0, 1, 108, 0, 0, 0, 1, 108, 0, 0, 0, 1, 97, 1, 106, 106, 106}
+
+	cases := []struct {
+		limits Limits
+		in     []byte
+		limit  string
+	}{
+		{Limits{MaxBytes: 10}, nested, "MaxBytes"},
+		{Limits{MaxDepth: 3}, nested, "MaxDepth"},
+		// {1,2,3}
+		{Limits{MaxElements: 2}, []byte{104, 3, 97, 1, 97, 2, 97, 3}, "MaxElements"},
+		// #{1 => 2}
+		{Limits{MaxElements: 0}, []byte{116, 0, 0, 0, 1, 97, 1, 97, 2}, ""},
+		// <<1,2,3>>
+		{Limits{MaxBinary: 2}, []byte{109, 0, 0, 0, 3, 1, 2, 3}, "MaxBinary"},
+		{Limits{MaxBinary: 3}, []byte{109, 0, 0, 0, 3, 1, 2, 3}, ""},
+		// 1 bsl 64
+		{Limits{MaxBigInt: 8}, []byte{110, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, "MaxBigInt"},
+		{Limits{MaxDepth: 4, MaxBytes: 20}, nested, ""},
+	}
+
+	for _, tc := range cases {
+		c := &Context{Limits: tc.limits}
+		_, err := c.Read(bytes.NewReader(tc.in))
+		var le *ErrLimit
+		if tc.limit == "" {
+			if err != nil {
+				t.Errorf("%+v, %v: %v", tc.limits, tc.in, err)
+			}
+		} else if !errors.As(err, &le) {
+			t.Errorf("%+v, %v: expected ErrLimit, got %v", tc.limits, tc.in, err)
+		} else if le.Limit != tc.limit {
+			t.Errorf("%+v, %v: expected %s exceeded, got %v", tc.limits, tc.in, tc.limit, err)
+		}
+	}
+}
+
+func TestReadHugeSizes(t *testing.T) {
+	cases := [][]byte{
+		// a binary, a list, a tuple and a map of 4G claimed elements
+		{109, 255, 255, 255, 255, 1},
+		{108, 255, 255, 255, 255, 97, 1},
+		{105, 255, 255, 255, 255, 97, 1},
+		{116, 255, 255, 255, 255, 97, 1},
+		{110, 255, 0, 1},
+		{111, 255, 255, 255, 255, 0, 1},
+	}
+
+	for _, in := range cases {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if _, err := new(Context).Read(bytes.NewReader(in)); err == nil {
+			t.Errorf("%v: expected an error", in)
+		}
+		runtime.ReadMemStats(&after)
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			t.Errorf("%v: allocated %d bytes", in, n)
+		}
+	}
+}
+
+func TestDecoderLimits(t *testing.T) {
+	// 131, {1,2,3}, 131, [1,2]
+	in := []byte{131, 104, 3, 97, 1, 97, 2, 97, 3, 131, 107, 0, 2, 1, 2}
+	dec := NewDecoder(bytes.NewReader(in))
+	dec.Context.Limits.MaxElements = 2
+
+	var v interface{}
+	var le *ErrLimit
+	if err := dec.Decode(&v); !errors.As(err, &le) {
+		t.Errorf("expected ErrLimit, got %v", err)
+	}
+}
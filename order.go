@@ -0,0 +1,418 @@
+package etf
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Erlang term order classes:
+// number < atom < reference < fun < port < pid < tuple < map < nil < list < bitstring
+const (
+	orderNumber = iota
+	orderAtom
+	orderRef
+	orderFun
+	orderPort
+	orderPid
+	orderTuple
+	orderMap
+	orderNil
+	orderList
+	orderBitstring
+	orderUnknown
+)
+
+// Compare returns -1, 0 or 1 as a is less than, equal to or greater
+// than b in Erlang's standard term order:
+//
+//	number < atom < reference < fun < port < pid < tuple < map < nil < list < bitstring
+//
+// Numbers compare by value whatever their Go type, so 1 and 1.0 are
+// equal, as with == in Erlang. Map keys compare as they are ordered in
+// maps, where 1 is less than 1.0. Go values compare as what Write
+// encodes them as; other values are equal to each other and greater
+// than all terms.
+func Compare(a, b Term) int {
+	return compare(a, b, false)
+}
+
+// Equal reports whether a and b are equal in value, as a == b in
+// Erlang: numbers compare arithmetically, so 1 equals 1.0.
+func Equal(a, b Term) bool {
+	return compare(a, b, false) == 0
+}
+
+// ExactEqual reports whether a and b are the same term, as a =:= b in
+// Erlang: an integer never equals a float. Integers of different Go
+// types, and strings and the lists they encode as, are the same term.
+func ExactEqual(a, b Term) bool {
+	return compare(a, b, true) == 0
+}
+
+// compareTerms orders terms the way Erlang orders map keys: by standard
+// term order, except that all integers sort before all floats.
+func compareTerms(a, b Term) int {
+	return compare(a, b, true)
+}
+
+// compare orders terms by standard term order, with integers before
+// floats if exact is set, or else by their value.
+func compare(a, b Term, exact bool) int {
+	a, b = structTerm(a), structTerm(b)
+	ca, cb := orderClass(a), orderClass(b)
+	if ca != cb {
+		return compareInts(int64(ca), int64(cb))
+	}
+
+	switch ca {
+	case orderNumber:
+		ai, af, aFloat := number(a)
+		bi, bf, bFloat := number(b)
+		switch {
+		case aFloat && bFloat:
+			return compareFloats(af, bf)
+		case aFloat && exact:
+			return 1
+		case bFloat && exact:
+			return -1
+		case aFloat:
+			return -compareNumber(bi, af)
+		case bFloat:
+			return compareNumber(ai, bf)
+		}
+		return ai.Cmp(bi)
+
+	case orderAtom:
+		return strings.Compare(atomText(a), atomText(b))
+
+	case orderRef:
+		x, y := a.(Ref), b.(Ref)
+		if r := strings.Compare(string(x.Node), string(y.Node)); r != 0 {
+			return r
+		} else if r = compareInts(int64(len(x.Id)), int64(len(y.Id))); r != 0 {
+			return r
+		}
+		for i := len(x.Id) - 1; i >= 0; i-- {
+			if r := compareInts(int64(x.Id[i]), int64(y.Id[i])); r != 0 {
+				return r
+			}
+		}
+		return compareInts(int64(x.Creation), int64(y.Creation))
+
+	case orderFun:
+		return compareFuns(a, b, exact)
+
+	case orderPort:
+		x, y := a.(Port), b.(Port)
+		if r := strings.Compare(string(x.Node), string(y.Node)); r != 0 {
+			return r
+		} else if r = compareUints(x.Id, y.Id); r != 0 {
+			return r
+		}
+		return compareInts(int64(x.Creation), int64(y.Creation))
+
+	case orderPid:
+		x, y := a.(Pid), b.(Pid)
+		if r := strings.Compare(string(x.Node), string(y.Node)); r != 0 {
+			return r
+		} else if r = compareInts(int64(x.Serial), int64(y.Serial)); r != 0 {
+			return r
+		} else if r = compareInts(int64(x.Id), int64(y.Id)); r != 0 {
+			return r
+		}
+		return compareInts(int64(x.Creation), int64(y.Creation))
+
+	case orderTuple:
+		x, y := a.(Tuple), b.(Tuple)
+		if r := compareInts(int64(len(x)), int64(len(y))); r != 0 {
+			return r
+		}
+		return compareSeqs(x, y, exact)
+
+	case orderMap:
+		return compareMaps(a, b, exact)
+
+	case orderList:
+		return compareLists(a, b, exact)
+
+	case orderBitstring:
+		return compareBits(bits(a), bits(b))
+	}
+
+	return 0
+}
+
+func orderClass(t Term) int {
+	switch v := t.(type) {
+	case int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64, uintptr,
+		float32, float64, *big.Int:
+		return orderNumber
+	case Atom, bool:
+		return orderAtom
+	case Ref:
+		return orderRef
+	case Function, Export:
+		return orderFun
+	case Port:
+		return orderPort
+	case Pid:
+		return orderPid
+	case Tuple:
+		return orderTuple
+	case Map:
+		return orderMap
+	case []byte, BitString:
+		return orderBitstring
+	case string:
+		if v == "" {
+			return orderNil
+		}
+		return orderList
+	case List:
+		if len(v) == 0 {
+			return orderNil
+		}
+		return orderList
+	case ImproperList:
+		return orderList
+	}
+
+	switch rv := reflect.ValueOf(t); rv.Kind() {
+	case reflect.Map:
+		return orderMap
+	case reflect.Array, reflect.Slice:
+		if rv.Len() == 0 {
+			return orderNil
+		}
+		return orderList
+	}
+
+	return orderUnknown
+}
+
+// number returns t as an integer, or as a float if isFloat is set.
+func number(t Term) (i *big.Int, f float64, isFloat bool) {
+	switch v := t.(type) {
+	case float64:
+		return nil, v, true
+	case float32:
+		return nil, float64(v), true
+	case *big.Int:
+		return v, 0, false
+	}
+
+	rv := reflect.ValueOf(t)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i = big.NewInt(rv.Int())
+	default:
+		i = new(big.Int).SetUint64(rv.Uint())
+	}
+	return
+}
+
+func atomText(t Term) string {
+	switch v := t.(type) {
+	case Atom:
+		return string(v)
+	case bool:
+		if v {
+			return "true"
+		}
+	}
+	return "false"
+}
+
+// compareNumber compares an integer to a float by their value.
+func compareNumber(i *big.Int, f float64) int {
+	if math.IsNaN(f) {
+		return 0
+	}
+	return new(big.Float).SetInt(i).Cmp(big.NewFloat(f))
+}
+
+func compareFuns(a, b Term, exact bool) int {
+	switch x := a.(type) {
+	case Function:
+		y, ok := b.(Function)
+		if !ok {
+			return -1
+		}
+		if r := strings.Compare(string(x.Module), string(y.Module)); r != 0 {
+			return r
+		} else if r = compareInts(int64(x.OldIndex), int64(y.OldIndex)); r != 0 {
+			return r
+		} else if r = compareInts(int64(x.OldUnique), int64(y.OldUnique)); r != 0 {
+			return r
+		}
+		return compareSeqs(x.FreeVars, y.FreeVars, exact)
+
+	case Export:
+		y, ok := b.(Export)
+		if !ok {
+			return 1
+		}
+		if r := strings.Compare(string(x.Module), string(y.Module)); r != 0 {
+			return r
+		} else if r = strings.Compare(string(x.Function), string(y.Function)); r != 0 {
+			return r
+		}
+		return compareInts(int64(x.Arity), int64(y.Arity))
+	}
+
+	return 0
+}
+
+// compareMaps compares maps by size, then by their keys in map key
+// order, and then by their values.
+func compareMaps(a, b Term, exact bool) int {
+	x, y := mapTerm(a), mapTerm(b)
+	if r := compareInts(int64(len(x)), int64(len(y))); r != 0 {
+		return r
+	}
+
+	x, y = sortedMap(x), sortedMap(y)
+	for i := range x {
+		if r := compareTerms(x[i].Key, y[i].Key); r != 0 {
+			return r
+		}
+	}
+	for i := range x {
+		if r := compare(x[i].Value, y[i].Value, exact); r != 0 {
+			return r
+		}
+	}
+
+	return 0
+}
+
+// mapTerm returns a Map or a Go map as a Map.
+func mapTerm(t Term) Map {
+	if m, ok := t.(Map); ok {
+		return m
+	}
+	return new(Context).goMap(reflect.ValueOf(t))
+}
+
+// sortedMap returns a copy of m with its pairs in map key order.
+func sortedMap(m Map) Map {
+	s := make(Map, len(m))
+	copy(s, m)
+	sort.SliceStable(s, func(i, j int) bool {
+		return compareTerms(s[i].Key, s[j].Key) < 0
+	})
+	return s
+}
+
+func compareSeqs(x, y []Term, exact bool) int {
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if r := compare(x[i], y[i], exact); r != 0 {
+			return r
+		}
+	}
+	return compareInts(int64(len(x)), int64(len(y)))
+}
+
+// structTerm returns the term a struct is encoded as, or t if
+// t isn't a struct.
+func structTerm(t Term) Term {
+	if rv := reflect.ValueOf(t); rv.Kind() == reflect.Struct {
+		switch t.(type) {
+		case Pid, Port, Ref, Function, Export, BitString, ImproperList:
+			return t
+		}
+		return getStructInfo(rv.Type()).term(rv)
+	}
+	return t
+}
+
+// compareLists compares lists element by element, and then by what
+// follows in the shorter one, which is its tail.
+func compareLists(a, b Term, exact bool) int {
+	x, xt := listParts(a)
+	y, yt := listParts(b)
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if r := compare(x[i], y[i], exact); r != 0 {
+			return r
+		}
+	}
+
+	switch {
+	case len(x) < len(y):
+		return compare(xt, improper(y[len(x):], yt), exact)
+	case len(x) > len(y):
+		return compare(improper(x[len(y):], xt), yt, exact)
+	}
+	return compare(xt, yt, exact)
+}
+
+// listParts returns the elements and the tail of a list.
+func listParts(t Term) ([]Term, Term) {
+	if l, ok := t.(ImproperList); ok {
+		return l.Elems, l.Tail
+	}
+	return listElems(t), List{}
+}
+
+// improper returns the list of elems followed by tail.
+func improper(elems []Term, tail Term) Term {
+	if l, ok := tail.(List); ok && len(l) == 0 {
+		return List(elems)
+	}
+	return ImproperList{elems, tail}
+}
+
+func listElems(t Term) []Term {
+	switch v := t.(type) {
+	case List:
+		return v
+	case string:
+		// the list of its bytes, as Write encodes it
+		elems := make([]Term, len(v))
+		for i := 0; i < len(v); i++ {
+			elems[i] = int(v[i])
+		}
+		return elems
+	}
+
+	rv := reflect.ValueOf(t)
+	elems := make([]Term, rv.Len())
+	for i := range elems {
+		elems[i] = rv.Index(i).Interface()
+	}
+	return elems
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareUints(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
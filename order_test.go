@@ -0,0 +1,99 @@
+package etf
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestCompareTerms(t *testing.T) {
+	// each term is smaller than the next one
+	terms := []Term{
+		-1,
+		0,
+		big.NewInt(1 << 40),
+		-1.5,
+		0.5,
+		Atom("a"),
+		Atom("b"),
+		false,
+		Ref{Atom("a@b"), 0, []uint32{2, 1}},
+		Ref{Atom("a@b"), 0, []uint32{1, 2}},
+		Export{Atom("m"), Atom("f"), 1},
+		Port{Atom("a@b"), 1, 0},
+		Pid{Atom("a@b"), 1, 0, 0},
+		Tuple{Atom("z")},
+		Tuple{Atom("a"), 1},
+		Map{{Atom("a"), 1}},
+		List{},
+		ImproperList{List{1}, 0},
+		List{1},
+		ImproperList{List{1, 1}, 0},
+		ImproperList{List{1}, List{1}},
+		"ab",
+		List{Atom("a")},
+		[]byte{},
+		[]byte{0},
+	}
+
+	for i := range terms {
+		for j := range terms {
+			exp := compareInts(int64(i), int64(j))
+			if r := compareTerms(terms[i], terms[j]); r != exp {
+				t.Errorf("compare(%v, %v): expected %d, got %d", terms[i], terms[j], exp, r)
+			}
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	big1 := new(big.Int).Lsh(big.NewInt(1), 70)
+	cases := []struct {
+		a, b  Term
+		cmp   int
+		exact bool
+	}{
+		{1, 1.0, 0, false},
+		{1, 1.5, -1, false},
+		{2, 1.5, 1, false},
+		{int64(-3), -3.0, 0, false},
+		{uint8(3), int64(3), 0, true},
+		{big1, 1e21, 1, false},
+		{big1, 0x1p70, 0, false},
+		{big1, 1.18e21, 1, false},
+		{new(big.Int).Add(big1, big.NewInt(1)), 0x1p70, 1, false},
+		{1.5, big.NewInt(2), -1, false},
+		{big.NewInt(5), 5, 0, true},
+		{1e300, Atom("a"), -1, false},
+		{Tuple{1, Atom("a")}, Tuple{1.0, Atom("a")}, 0, false},
+		{Tuple{1, 2}, Tuple{1.0, 3}, -1, false},
+		{List{1, 2.0}, List{1.0, 2}, 0, false},
+		{ImproperList{List{1}, 2}, ImproperList{List{1.0}, 2.0}, 0, false},
+		{"ab", List{97, 98}, 0, true},
+		{"ab", List{97.0, 98}, 0, false},
+		{"\xc3\xa9", List{195, 169}, 0, true},
+		{"é", List{233}, -1, false},
+		{"é", Charlist("é"), -1, false},
+		{"\xe9", Charlist("é"), 0, true},
+		{Map{{Atom("a"), 1}}, Map{{Atom("a"), 1.0}}, 0, false},
+		{Map{{1, Atom("a")}}, Map{{1.0, Atom("a")}}, -1, false},
+		{map[Atom]int{"a": 1, "b": 2}, Map{{Atom("b"), 2}, {Atom("a"), 1}}, 0, true},
+		{map[Atom]int{"a": 1}, Map{{Atom("a"), 2}}, -1, false},
+		{[]byte("a"), BitString{[]byte("a"), 8}, 0, true},
+		{Atom("true"), true, 0, true},
+	}
+
+	for _, tc := range cases {
+		if r := Compare(tc.a, tc.b); r != tc.cmp {
+			t.Errorf("Compare(%v, %v): expected %d, got %d", tc.a, tc.b, tc.cmp, r)
+		}
+		if r := Compare(tc.b, tc.a); r != -tc.cmp {
+			t.Errorf("Compare(%v, %v): expected %d, got %d", tc.b, tc.a, -tc.cmp, r)
+		}
+		if eq := Equal(tc.a, tc.b); eq != (tc.cmp == 0) {
+			t.Errorf("Equal(%v, %v) == %v", tc.a, tc.b, eq)
+		}
+		if eq := ExactEqual(tc.a, tc.b); eq != tc.exact {
+			t.Errorf("ExactEqual(%v, %v) == %v", tc.a, tc.b, eq)
+		}
+	}
+}
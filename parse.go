@@ -0,0 +1,904 @@
+package etf
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrParse is returned by Parse for text that isn't an Erlang term.
+type ErrParse struct {
+	Line, Column int
+	Msg          string
+}
+
+func (e *ErrParse) Error() string {
+	return fmt.Sprintf("parse: line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// Parse parses an Erlang term written as in Erlang source or as the
+// Erlang shell prints it, optionally followed by a full stop:
+//
+//	{ok, [1, 2.5, $a, 16#ff, <<"x", 1:4>>, #{a => "b"}, <0.42.0>]}.
+//
+// Terms map to the types Read returns: atoms to Atom, true and false
+// to bool, strings to the Go string of their Latin-1 bytes, or to a
+// List of their characters if any is above 255, binaries to []byte or
+// BitString, integers to int or *big.Int, and [H|T] to ImproperList.
+// So "é" parses as "\xe9", as term_to_binary("é") reads. Pids,
+// references and ports are local, as in <0.42.0>, #Ref<0.1.2.3> and
+// #Port<0.5>, and belong to nonode@nohost, or name their node as
+// Sprint prints them, as in <a@b.42.0>. fun M:F/A parses as an
+// Export.
+func Parse(s string) (Term, error) {
+	p := &parser{s: s, line: 1}
+	t, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	p.space()
+	if p.peek() == '.' {
+		p.next()
+		p.space()
+	}
+	if p.pos < len(p.s) {
+		return nil, p.errorf("unexpected %q after term", p.peek())
+	}
+	return t, nil
+}
+
+// Erlang reserved words, which can't be unquoted atoms.
+var reservedWords = map[string]bool{
+	"after": true, "and": true, "andalso": true, "band": true,
+	"begin": true, "bnot": true, "bor": true, "bsl": true, "bsr": true,
+	"bxor": true, "case": true, "catch": true, "cond": true, "div": true,
+	"else": true, "end": true, "fun": true, "if": true, "let": true,
+	"maybe": true, "not": true, "of": true, "or": true, "orelse": true,
+	"receive": true, "rem": true, "try": true, "when": true, "xor": true,
+}
+
+const localNode = Atom("nonode@nohost")
+
+type parser struct {
+	s         string
+	pos       int
+	line      int
+	lineStart int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	col := utf8.RuneCountInString(p.s[p.lineStart:p.pos]) + 1
+	return &ErrParse{p.line, col, fmt.Sprintf(format, args...)}
+}
+
+// peek returns the next rune, or -1 at the end of the input.
+func (p *parser) peek() rune {
+	if p.pos >= len(p.s) {
+		return -1
+	}
+	r, _ := utf8.DecodeRuneInString(p.s[p.pos:])
+	return r
+}
+
+func (p *parser) next() rune {
+	if p.pos >= len(p.s) {
+		return -1
+	}
+	r, n := utf8.DecodeRuneInString(p.s[p.pos:])
+	p.pos += n
+	if r == '\n' {
+		p.line++
+		p.lineStart = p.pos
+	}
+	return r
+}
+
+// space skips white space and comments.
+func (p *parser) space() {
+	for {
+		switch r := p.peek(); {
+		case r == '%':
+			for r = p.peek(); r != '\n' && r != -1; r = p.peek() {
+				p.next()
+			}
+		case r != -1 && unicode.IsSpace(r):
+			p.next()
+		default:
+			return
+		}
+	}
+}
+
+// expect skips white space and the token tok, or fails.
+func (p *parser) expect(tok string) error {
+	p.space()
+	if !strings.HasPrefix(p.s[p.pos:], tok) {
+		if p.pos == len(p.s) {
+			return p.errorf("expected %q, got end of input", tok)
+		}
+		return p.errorf("expected %q, got %q", tok, p.peek())
+	}
+	for range tok {
+		p.next()
+	}
+	return nil
+}
+
+// accept skips white space and the token tok if it is next.
+func (p *parser) accept(tok string) bool {
+	p.space()
+	if strings.HasPrefix(p.s[p.pos:], tok) {
+		for range tok {
+			p.next()
+		}
+		return true
+	}
+	return false
+}
+
+func (p *parser) term() (Term, error) {
+	p.space()
+	switch r := p.peek(); {
+	case r == -1:
+		return nil, p.errorf("unexpected end of input")
+	case r == '{':
+		p.next()
+		elems, err := p.elements("}")
+		return Tuple(elems), err
+	case r == '[':
+		return p.list()
+	case r == '#':
+		return p.hash()
+	case r == '<':
+		if strings.HasPrefix(p.s[p.pos:], "<<") {
+			return p.binary()
+		}
+		p.next()
+		return p.pid()
+	case r == '"':
+		return p.strings()
+	case r == '\'':
+		return p.quotedAtom()
+	case r == '$', r == '-', r == '+', r >= '0' && r <= '9':
+		return p.number()
+	case unicode.IsLower(r):
+		return p.atom()
+	}
+	return nil, p.errorf("unexpected %q", p.peek())
+}
+
+// elements parses terms separated by commas up to the closing token.
+func (p *parser) elements(closing string) ([]Term, error) {
+	elems := []Term{}
+	if p.accept(closing) {
+		return elems, nil
+	}
+	for {
+		t, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, t)
+		if p.accept(",") {
+			continue
+		}
+		return elems, p.expect(closing)
+	}
+}
+
+func (p *parser) list() (Term, error) {
+	p.next()
+	l := List{}
+	if p.accept("]") {
+		return l, nil
+	}
+	for {
+		t, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, t)
+		if p.accept(",") {
+			continue
+		} else if p.accept("|") {
+			tail, err := p.term()
+			if err != nil {
+				return nil, err
+			} else if err = p.expect("]"); err != nil {
+				return nil, err
+			}
+			if tl, ok := tail.(List); ok {
+				return append(l, tl...), nil
+			}
+			return ImproperList{l, tail}, nil
+		}
+		return l, p.expect("]")
+	}
+}
+
+// hash parses the terms starting with #: maps, references and ports.
+func (p *parser) hash() (Term, error) {
+	p.next()
+	switch {
+	case p.accept("{"):
+		return p.mapPairs()
+	case strings.HasPrefix(p.s[p.pos:], "Ref<"):
+		p.pos += len("Ref<")
+		node, ids, err := p.nodeIds(3, 32)
+		if err != nil {
+			return nil, err
+		}
+		// printed most significant first
+		return Ref{Node: node, Id: []uint32{uint32(ids[2]), uint32(ids[1]), uint32(ids[0])}}, nil
+	case strings.HasPrefix(p.s[p.pos:], "Port<"):
+		p.pos += len("Port<")
+		node, ids, err := p.nodeIds(1, 64)
+		if err != nil {
+			return nil, err
+		}
+		return Port{Node: node, Id: ids[0]}, nil
+	}
+	return nil, p.errorf("unexpected %q after #", p.peek())
+}
+
+func (p *parser) mapPairs() (Term, error) {
+	m := Map{}
+	if p.accept("}") {
+		return m, nil
+	}
+	for {
+		k, err := p.term()
+		if err != nil {
+			return nil, err
+		} else if err = p.expect("=>"); err != nil {
+			return nil, err
+		}
+		v, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, MapElem{k, v})
+		if p.accept(",") {
+			continue
+		}
+		return m, p.expect("}")
+	}
+}
+
+func (p *parser) pid() (Term, error) {
+	node, ids, err := p.nodeIds(2, 32)
+	if err != nil {
+		return nil, err
+	}
+	return Pid{Node: node, Id: uint32(ids[0]), Serial: uint32(ids[1])}, nil
+}
+
+// nodeIds parses what follows the < of a pid, reference or port up to
+// the closing >: the node, then n dot separated numbers of the given
+// bit size. The node is 0 for the local node, as the Erlang shell
+// prints it, or the node name, as Sprint prints remote identifiers.
+// Other node numbers only mean something to the shell that printed
+// them, and are rejected.
+func (p *parser) nodeIds(n, bitSize int) (node Atom, ids []uint64, err error) {
+	switch r := p.peek(); {
+	case r >= '0' && r <= '9':
+		start := p.pos
+		for r := p.peek(); r >= '0' && r <= '9'; r = p.peek() {
+			p.next()
+		}
+		if num := p.s[start:p.pos]; num != "0" {
+			return "", nil, p.errorf("identifier of remote node %s", num)
+		}
+		node = localNode
+	case r == '\'':
+		var t Term
+		if t, err = p.quotedAtom(); err != nil {
+			return
+		}
+		node = t.(Atom)
+	default:
+		start := p.pos
+		for r := p.peek(); r == '_' || r == '@' || unicode.IsLetter(r) || unicode.IsDigit(r); r = p.peek() {
+			p.next()
+		}
+		if node = Atom(p.s[start:p.pos]); node == "" {
+			return "", nil, p.errorf("expected node in identifier")
+		}
+	}
+
+	ids = make([]uint64, n)
+	for i := range ids {
+		if p.next() != '.' {
+			return "", nil, p.errorf("expected '.' in identifier")
+		}
+		start := p.pos
+		for r := p.peek(); r >= '0' && r <= '9'; r = p.peek() {
+			p.next()
+		}
+		if ids[i], err = strconv.ParseUint(p.s[start:p.pos], 10, bitSize); err != nil {
+			return "", nil, p.errorf("bad number in identifier")
+		}
+	}
+	if p.next() != '>' {
+		return "", nil, p.errorf("expected '>' after identifier")
+	}
+	return node, ids, nil
+}
+
+func (p *parser) atom() (Term, error) {
+	start := p.pos
+	for r := p.peek(); r == '_' || r == '@' || unicode.IsLetter(r) || unicode.IsDigit(r); r = p.peek() {
+		p.next()
+	}
+	name := p.s[start:p.pos]
+
+	switch {
+	case name == "true":
+		return true, nil
+	case name == "false":
+		return false, nil
+	case name == "fun":
+		return p.export()
+	case reservedWords[name]:
+		return nil, p.errorf("reserved word %s", name)
+	case utf8.RuneCountInString(name) > maxAtomChars:
+		return nil, p.errorf("atom is too big")
+	}
+	return Atom(name), nil
+}
+
+func (p *parser) quotedAtom() (Term, error) {
+	s, err := p.quoted('\'')
+	if err != nil {
+		return nil, err
+	} else if utf8.RuneCountInString(s) > maxAtomChars {
+		return nil, p.errorf("atom is too big")
+	}
+	return Atom(s), nil
+}
+
+// export parses what follows fun in fun M:F/A.
+func (p *parser) export() (Term, error) {
+	var e Export
+	atom := func() (Atom, error) {
+		t, err := p.term()
+		switch a := t.(type) {
+		case Atom:
+			return a, err
+		case bool:
+			return Atom(atomText(a)), err
+		}
+		if err == nil {
+			err = p.errorf("expected an atom in fun M:F/A")
+		}
+		return "", err
+	}
+
+	var err error
+	if e.Module, err = atom(); err != nil {
+		return nil, err
+	} else if err = p.expect(":"); err != nil {
+		return nil, err
+	} else if e.Function, err = atom(); err != nil {
+		return nil, err
+	} else if err = p.expect("/"); err != nil {
+		return nil, err
+	}
+	p.space()
+	a, err := p.number()
+	if x, ok := a.(int); err == nil && ok && x >= 0 && x <= math.MaxUint8 {
+		e.Arity = byte(x)
+		return e, nil
+	} else if err == nil {
+		err = p.errorf("bad arity in fun M:F/A")
+	}
+	return nil, err
+}
+
+// strings parses adjacent string literals, which are concatenated, as
+// the term Read returns for the string term_to_binary encodes: the Go
+// string of its Latin-1 bytes, or the List of its characters if it is
+// empty, too long for STRING_EXT, or has characters above 255.
+func (p *parser) strings() (Term, error) {
+	s, err := p.text()
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > math.MaxUint8 {
+			return stringTerm(s, StringList), nil
+		}
+		b = append(b, byte(r))
+	}
+	if len(b) == 0 || len(b) > math.MaxUint16 {
+		return stringTerm(s, StringList), nil
+	}
+	return string(b), nil
+}
+
+// text parses adjacent string literals into the UTF-8 text they
+// concatenate to.
+func (p *parser) text() (string, error) {
+	var b strings.Builder
+	for p.peek() == '"' {
+		s, err := p.quoted('"')
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s)
+		p.space()
+	}
+	return b.String(), nil
+}
+
+// quoted parses a quoted atom or string, handling escapes.
+func (p *parser) quoted(q rune) (string, error) {
+	p.next()
+	var b strings.Builder
+	for {
+		switch r := p.next(); r {
+		case -1:
+			return "", p.errorf("unterminated %c", q)
+		case q:
+			return b.String(), nil
+		case '\\':
+			c, err := p.escape()
+			if err != nil {
+				return "", err
+			}
+			b.WriteRune(c)
+		default:
+			b.WriteRune(r)
+		}
+	}
+}
+
+var escapes = map[rune]rune{
+	'b': '\b', 'd': 0x7f, 'e': 0x1b, 'f': '\f', 'n': '\n', 'r': '\r',
+	's': ' ', 't': '\t', 'v': '\v',
+}
+
+// escape parses what follows a backslash.
+func (p *parser) escape() (rune, error) {
+	r := p.next()
+	switch {
+	case r == -1:
+		return 0, p.errorf("unterminated escape")
+	case escapes[r] != 0:
+		return escapes[r], nil
+	case r >= '0' && r <= '7':
+		c := r - '0'
+		for i := 0; i < 2 && p.peek() >= '0' && p.peek() <= '7'; i++ {
+			c = c*8 + p.next() - '0'
+		}
+		return c, nil
+	case r == 'x':
+		start := p.pos
+		if p.peek() == '{' {
+			p.next()
+			start = p.pos
+			for r := p.peek(); r != '}' && r != -1; r = p.peek() {
+				p.next()
+			}
+			digits := p.s[start:p.pos]
+			if p.next() != '}' {
+				return 0, p.errorf("unterminated \\x{")
+			}
+			return p.hexRune(digits)
+		}
+		for i := 0; i < 2 && isHex(p.peek()); i++ {
+			p.next()
+		}
+		return p.hexRune(p.s[start:p.pos])
+	case r == '^':
+		c := p.next()
+		if c == -1 {
+			return 0, p.errorf("unterminated escape")
+		}
+		return c & 0x1f, nil
+	}
+	return r, nil
+}
+
+func (p *parser) hexRune(digits string) (rune, error) {
+	x, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || x > unicode.MaxRune {
+		return 0, p.errorf("bad escape \\x%s", digits)
+	}
+	return rune(x), nil
+}
+
+func isHex(r rune) bool {
+	return r >= '0' && r <= '9' || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F'
+}
+
+// number parses an integer, a float or a character, with an optional
+// sign.
+func (p *parser) number() (Term, error) {
+	neg := false
+	switch p.peek() {
+	case '-':
+		neg = true
+		fallthrough
+	case '+':
+		p.next()
+		p.space()
+	}
+
+	var t Term
+	var err error
+	if p.peek() == '$' {
+		p.next()
+		r := p.next()
+		if r == '\\' {
+			r, err = p.escape()
+		} else if r == -1 {
+			err = p.errorf("unterminated character")
+		}
+		t = int(r)
+	} else {
+		t, err = p.unsigned()
+	}
+	if err != nil || !neg {
+		return t, err
+	}
+
+	switch v := t.(type) {
+	case int:
+		return integerTerm(new(big.Int).Neg(big.NewInt(int64(v)))), nil
+	case *big.Int:
+		return integerTerm(new(big.Int).Neg(v)), nil
+	case float64:
+		return -v, nil
+	}
+	return t, nil
+}
+
+// unsigned parses an integer, in base 10 or as Base#Digits, or a float.
+func (p *parser) unsigned() (Term, error) {
+	digits := p.digits(10)
+	if digits == "" {
+		return nil, p.errorf("expected a number, got %q", p.peek())
+	}
+
+	switch {
+	case p.peek() == '#':
+		base, err := strconv.Atoi(digits)
+		if err != nil || base < 2 || base > 36 {
+			return nil, p.errorf("bad base %s", digits)
+		}
+		p.next()
+		if digits = p.digits(base); digits == "" {
+			return nil, p.errorf("expected base %d digits", base)
+		}
+		x, _ := new(big.Int).SetString(digits, base)
+		return integerTerm(x), nil
+
+	case p.peek() == '.' && p.pos+1 < len(p.s) && p.s[p.pos+1] >= '0' && p.s[p.pos+1] <= '9':
+		p.next()
+		f := digits + "." + p.digits(10)
+		if r := p.peek(); r == 'e' || r == 'E' {
+			save := p.pos
+			p.next()
+			exp := "e"
+			if r := p.peek(); r == '+' || r == '-' {
+				exp += string(p.next())
+			}
+			if d := p.digits(10); d != "" {
+				f += exp + d
+			} else {
+				p.pos = save
+			}
+		}
+		x, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, p.errorf("bad float %s", f)
+		}
+		return x, nil
+	}
+
+	x, _ := new(big.Int).SetString(digits, 10)
+	return integerTerm(x), nil
+}
+
+// digits returns the digits in base found next, dropping the _
+// separators between them.
+func (p *parser) digits(base int) string {
+	var b strings.Builder
+	for {
+		r := p.peek()
+		if r == '_' && b.Len() > 0 && p.pos+1 < len(p.s) && digitValue(rune(p.s[p.pos+1])) < base {
+			p.next()
+			continue
+		} else if digitValue(r) >= base {
+			return b.String()
+		}
+		b.WriteRune(p.next())
+	}
+}
+
+func digitValue(r rune) int {
+	switch {
+	case r >= '0' && r <= '9':
+		return int(r - '0')
+	case r >= 'a' && r <= 'z':
+		return int(r-'a') + 10
+	case r >= 'A' && r <= 'Z':
+		return int(r-'A') + 10
+	}
+	return math.MaxInt32
+}
+
+// integerTerm returns x as an int if it fits.
+func integerTerm(x *big.Int) Term {
+	if x.IsInt64() && int64(int(x.Int64())) == x.Int64() {
+		return int(x.Int64())
+	}
+	return x
+}
+
+// binary parses a binary or bitstring, made of segments
+// Value[:Size][/Type-Specifiers].
+func (p *parser) binary() (Term, error) {
+	p.pos += len("<<")
+	var bs BitString
+	if !p.accept(">>") {
+		for {
+			var err error
+			if bs, err = p.segment(bs); err != nil {
+				return nil, err
+			} else if p.accept(",") {
+				continue
+			} else if err = p.expect(">>"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	if bs.Len()%8 != 0 {
+		return bs, nil
+	} else if bs.Bytes == nil {
+		return []byte{}, nil
+	}
+	return bs.Bytes, nil
+}
+
+// segmentSpec is the type and the size of a binary segment.
+type segmentSpec struct {
+	typ    string
+	size   int
+	unit   int
+	little bool
+}
+
+func (p *parser) segment(bs BitString) (BitString, error) {
+	p.space()
+	var value Term
+	var err error
+	switch r := p.peek(); {
+	case r == '"':
+		value, err = p.text()
+	case strings.HasPrefix(p.s[p.pos:], "<<"):
+		value, err = p.binary()
+	default:
+		value, err = p.number()
+	}
+	if err != nil {
+		return bs, err
+	}
+
+	spec := segmentSpec{size: -1}
+	if p.accept(":") {
+		p.space()
+		var size Term
+		if size, err = p.unsigned(); err != nil {
+			return bs, err
+		} else if n, ok := size.(int); !ok || n < 0 {
+			return bs, p.errorf("bad segment size %v", size)
+		} else {
+			spec.size = n
+		}
+	}
+	if p.accept("/") {
+		if err = p.specifiers(&spec); err != nil {
+			return bs, err
+		}
+	}
+
+	if s, ok := value.(string); ok {
+		// a string is a segment per character
+		if spec.typ == "" {
+			spec.typ = "integer"
+		}
+		for _, r := range s {
+			if bs, err = p.appendSegment(bs, int(r), spec); err != nil {
+				return bs, err
+			}
+		}
+		return bs, nil
+	}
+	return p.appendSegment(bs, value, spec)
+}
+
+func (p *parser) specifiers(spec *segmentSpec) error {
+	for {
+		p.space()
+		start := p.pos
+		for r := p.peek(); r >= 'a' && r <= 'z' || r >= '0' && r <= '9'; r = p.peek() {
+			p.next()
+		}
+		switch name := p.s[start:p.pos]; name {
+		case "integer", "float", "binary", "bytes", "bitstring", "bits",
+			"utf8", "utf16", "utf32":
+			spec.typ = name
+		case "signed", "unsigned":
+			// only matters for matching
+		case "big", "little":
+			spec.little = name == "little"
+		case "unit":
+			if !p.accept(":") {
+				return p.errorf("expected unit:N")
+			}
+			p.space()
+			u, err := p.unsigned()
+			if n, ok := u.(int); err == nil && ok && n >= 1 && n <= 256 {
+				spec.unit = n
+			} else if err == nil {
+				return p.errorf("bad unit %v", u)
+			} else {
+				return err
+			}
+		default:
+			return p.errorf("unknown type specifier %q", name)
+		}
+		if !p.accept("-") {
+			return nil
+		}
+	}
+}
+
+// appendSegment appends value, encoded as spec says, to bs.
+func (p *parser) appendSegment(bs BitString, value Term, spec segmentSpec) (BitString, error) {
+	typ := spec.typ
+	if typ == "" {
+		typ = "integer"
+	}
+
+	unit := spec.unit
+	switch typ {
+	case "integer", "float":
+		if unit == 0 {
+			unit = 1
+		}
+	case "binary", "bytes":
+		if unit == 0 {
+			unit = 8
+		}
+	case "bitstring", "bits":
+		if unit == 0 {
+			unit = 1
+		}
+	default:
+		if spec.size >= 0 || unit != 0 {
+			return bs, p.errorf("size of a %s segment", typ)
+		}
+	}
+	size := spec.size * unit
+
+	switch typ {
+	case "integer":
+		x, ok := integer(value)
+		if !ok {
+			return bs, p.errorf("%v is not an integer", value)
+		} else if spec.size < 0 {
+			size = 8
+		}
+		if spec.little && size%8 != 0 {
+			return bs, p.errorf("little endian segment of %d bits", size)
+		}
+		return appendInteger(bs, x, size, spec.little), nil
+
+	case "float":
+		var f float64
+		if x, ok := integer(value); ok {
+			f, _ = new(big.Float).SetInt(x).Float64()
+		} else if f, ok = value.(float64); !ok {
+			return bs, p.errorf("%v is not a number", value)
+		}
+		if spec.size < 0 {
+			size = 64
+		}
+		switch size {
+		case 32:
+			x := new(big.Int).SetUint64(uint64(math.Float32bits(float32(f))))
+			return appendInteger(bs, x, 32, spec.little), nil
+		case 64:
+			x := new(big.Int).SetUint64(math.Float64bits(f))
+			return appendInteger(bs, x, 64, spec.little), nil
+		}
+		return bs, p.errorf("float segment of %d bits", size)
+
+	case "binary", "bytes", "bitstring", "bits":
+		var v BitString
+		switch value.(type) {
+		case []byte, BitString:
+			v = bits(value)
+		default:
+			return bs, p.errorf("%v is not a binary", value)
+		}
+		if spec.size >= 0 {
+			if size > v.Len() {
+				return bs, p.errorf("binary of %d bits is shorter than its segment", v.Len())
+			}
+			v = v.Slice(0, size)
+		}
+		if (typ == "binary" || typ == "bytes") && v.Len()%8 != 0 {
+			return bs, p.errorf("bitstring in a binary segment")
+		}
+		return bs.Append(v), nil
+	}
+
+	// utf8, utf16 and utf32
+	c, ok := value.(int)
+	if !ok || c < 0 || c > unicode.MaxRune || typ != "utf32" && c >= 0xd800 && c <= 0xdfff {
+		return bs, p.errorf("%v is not a character", value)
+	}
+	switch typ {
+	case "utf8":
+		for _, b := range []byte(string(rune(c))) {
+			bs = bs.AppendBits(uint64(b), 8)
+		}
+	case "utf16":
+		units := []rune{rune(c)}
+		if c > 0xffff {
+			r1, r2 := utf16Pair(rune(c))
+			units = []rune{r1, r2}
+		}
+		for _, u := range units {
+			bs = appendInteger(bs, big.NewInt(int64(u)), 16, spec.little)
+		}
+	case "utf32":
+		bs = appendInteger(bs, big.NewInt(int64(c)), 32, spec.little)
+	}
+	return bs, nil
+}
+
+// utf16Pair returns the surrogate pair for r.
+func utf16Pair(r rune) (rune, rune) {
+	r -= 0x10000
+	return 0xd800 + (r>>10)&0x3ff, 0xdc00 + r&0x3ff
+}
+
+// appendInteger appends the n low bits of x in two's complement, most
+// significant first unless little is set, in which case n is a
+// multiple of 8.
+func appendInteger(bs BitString, x *big.Int, n int, little bool) BitString {
+	mod := new(big.Int).Lsh(big.NewInt(1), uint(n))
+	v := new(big.Int).Mod(x, mod)
+
+	if little {
+		b := v.FillBytes(make([]byte, n/8))
+		for i := len(b) - 1; i >= 0; i-- {
+			bs = bs.AppendBits(uint64(b[i]), 8)
+		}
+		return bs
+	}
+
+	for n > 0 {
+		m := n % 64
+		if m == 0 {
+			m = 64
+		}
+		n -= m
+		chunk := new(big.Int).Rsh(v, uint(n))
+		bs = bs.AppendBits(chunk.Uint64(), m)
+	}
+	return bs
+}
@@ -0,0 +1,178 @@
+package etf
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	big1, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	big2 := new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), 64))
+
+	cases := []struct {
+		in  string
+		exp Term
+	}{
+		{`ok`, Atom("ok")},
+		{`node@host_1`, Atom("node@host_1")},
+		{`'hello world'`, Atom("hello world")},
+		{`'it''s'`, nil},
+		{`'a\'b\n'`, Atom("a'b\n")},
+		{`'été'`, Atom("été")},
+		{`true`, true},
+		{`'false'`, Atom("false")},
+		{`42`, 42},
+		{`-42`, -42},
+		{`- 42`, -42},
+		{`1_000_000`, 1000000},
+		{`16#ff`, 255},
+		{`-2#1010`, -10},
+		{`36#Zz`, 36*35 + 35},
+		{`123456789012345678901234567890`, big1},
+		{`-18446744073709551616`, big2},
+		{`$a`, 97},
+		{`$\n`, 10},
+		{`$\x{20ac}`, 8364},
+		{`$\101`, 65},
+		{`$\^A`, 1},
+		{`$€`, 8364},
+		{`2.5`, 2.5},
+		{`-1.0e-3`, -0.001},
+		{`1.5E+2`, 150.0},
+		{`"hello"`, "hello"},
+		{`"a" "b"` + "\n" + `"c"`, "abc"},
+		{`"\t\x41\s"`, "\tA "},
+		{`""`, List{}},
+		{`"é"`, "\xe9"},
+		{`"\xff" "a"`, "\xffa"},
+		{`"a\x{400}"`, List{97, 1024}},
+		{`"€"`, List{8364}},
+		{`<<>>`, []byte{}},
+		{`<<"x">>`, []byte("x")},
+		{`<<1, 2, 255>>`, []byte{1, 2, 255}},
+		{`<<256, -1>>`, []byte{0, 255}},
+		{`<<1:16, 1:16/little>>`, []byte{0, 1, 1, 0}},
+		{`<<1:3>>`, BitString{[]byte{32}, 3}},
+		{`<<1:3, 0:5>>`, []byte{32}},
+		{`<<"é"/utf8, "é">>`, []byte{195, 169, 233}},
+		{`<<8364/utf16, 8364/utf16-little, 65/utf32>>`, []byte{32, 172, 172, 32, 0, 0, 0, 65}},
+		{`<<16#1f600/utf16>>`, []byte{0xd8, 0x3d, 0xde, 0x00}},
+		{`<<1.5/float>>`, []byte{63, 248, 0, 0, 0, 0, 0, 0}},
+		{`<<1.5:32/float>>`, []byte{63, 192, 0, 0}},
+		{`<<<<1, 2>>/binary, <<3:4>>/bits>>`, BitString{[]byte{1, 2, 48}, 4}},
+		{`<<<<1, 2>>:1/binary>>`, []byte{1}},
+		{`<<1:4/unit:8>>`, []byte{0, 0, 0, 1}},
+		{`{}`, Tuple{}},
+		{`{ok, 1}`, Tuple{Atom("ok"), 1}},
+		{`[]`, List{}},
+		{`[1, [2], {}]`, List{1, List{2}, Tuple{}}},
+		{`[a | b]`, ImproperList{List{Atom("a")}, Atom("b")}},
+		{`[a, b | [c]]`, List{Atom("a"), Atom("b"), Atom("c")}},
+		{`[a | []]`, List{Atom("a")}},
+		{`#{}`, Map{}},
+		{`#{b => 1, "a" => [x]}`, Map{{Atom("b"), 1}, {"a", List{Atom("x")}}}},
+		{`<0.42.0>`, Pid{Node: "nonode@nohost", Id: 42}},
+		{`#Ref<0.3.2.1>`, Ref{Node: "nonode@nohost", Id: []uint32{1, 2, 3}}},
+		{`#Port<0.5>`, Port{Node: "nonode@nohost", Id: 5}},
+		{`<a@b.1.2>`, Pid{Node: "a@b", Id: 1, Serial: 2}},
+		{`<'a@b.c'.1.2>`, Pid{Node: "a@b.c", Id: 1, Serial: 2}},
+		{`#Ref<a@b.3.2.1>`, Ref{Node: "a@b", Id: []uint32{1, 2, 3}}},
+		{`#Port<a@b.4294967296>`, Port{Node: "a@b", Id: 1 << 32}},
+		{`fun lists:map/2`, Export{"lists", "map", 2}},
+		{`fun 'a b':'if'/0`, Export{"a b", "if", 0}},
+		{"{ok, % comment\n 1}.\n", Tuple{Atom("ok"), 1}},
+		{` {ok, [1,2,<<"x">>]} . `, Tuple{Atom("ok"), List{1, 2, []byte("x")}}},
+	}
+
+	for _, tc := range cases {
+		v, err := Parse(tc.in)
+		if tc.exp == nil {
+			if err == nil {
+				t.Errorf("%s: err == nil", tc.in)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", tc.in, err)
+		} else if !reflect.DeepEqual(v, tc.exp) {
+			t.Errorf("%s: expected %#v, got %#v", tc.in, tc.exp, v)
+		}
+	}
+}
+
+func TestParseStrings(t *testing.T) {
+	// as term_to_binary encodes them
+	cases := []struct {
+		in  string
+		exp []byte
+	}{
+		{`"é"`, []byte{131, 107, 0, 1, 233}},
+		{`"a\x{400}"`, []byte{131, 108, 0, 0, 0, 2, 97, 97, 98, 0, 0, 4, 0, 106}},
+		{`""`, []byte{131, 106}},
+	}
+
+	for _, tc := range cases {
+		v, err := Parse(tc.in)
+		if err != nil {
+			t.Errorf("%s: %v", tc.in, err)
+			continue
+		}
+		if b, err := Marshal(v); err != nil {
+			t.Errorf("%s: %v", tc.in, err)
+		} else if !bytes.Equal(b, tc.exp) {
+			t.Errorf("%s: expected %v, got %v", tc.in, tc.exp, b)
+		}
+		if r, err := new(Context).Read(bytes.NewReader(tc.exp[1:])); err != nil {
+			t.Errorf("%v: %v", tc.exp, err)
+		} else if !reflect.DeepEqual(v, r) {
+			t.Errorf("%s: parsed %#v, read %#v", tc.in, v, r)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, in := range []string{
+		``,
+		`{ok`,
+		`{ok,}`,
+		`[1|2|3]`,
+		`#{a}`,
+		`Var`,
+		`_`,
+		`end`,
+		`'unterminated`,
+		`"unterminated`,
+		`1e10`,
+		`37#1`,
+		`2#2`,
+		`<1.2.3>`,
+		`<0.1>`,
+		`<a@b.1>`,
+		`<.1.2>`,
+		`<a@b.4294967296.0>`,
+		`ok ok`,
+		`ok..`,
+		`<<1.5>>`,
+		`<<"x"/binary>>`,
+		`<<1:3/little>>`,
+		`<<1:7/float>>`,
+		`<<<<1:4>>/binary>>`,
+		`<<-1/utf8>>`,
+		`<<1/foo>>`,
+		`fun m:f/256`,
+		`fun m:f`,
+	} {
+		_, err := Parse(in)
+		var pe *ErrParse
+		if !errors.As(err, &pe) {
+			t.Errorf("%q: expected ErrParse, got %v", in, err)
+		}
+	}
+
+	_, err := Parse("{ok,\n  [1, 2 3]}")
+	exp := &ErrParse{2, 9, `expected "]", got '3'`}
+	if !reflect.DeepEqual(err, exp) {
+		t.Errorf("expected %v, got %v", exp, err)
+	}
+}
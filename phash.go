@@ -0,0 +1,388 @@
+package etf
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"reflect"
+)
+
+// Phash2 returns erlang:phash2(Term, Range) for the term and range
+// rangeN: the portable hash of term in the range 0 to rangeN-1. A
+// rangeN of 0 stands for 2^32, and 1 << 27 gives erlang:phash2(Term).
+//
+// Go values hash as the terms Write encodes them as, so a string hashes
+// as the list of its bytes, a struct as a tuple, and 1 and int64(1) as
+// the same integer. It fails with ErrUnknownType for values that can't
+// be encoded.
+func Phash2(term Term, rangeN uint32) (uint32, error) {
+	var h hash2
+	if err := h.term(term); err != nil {
+		return 0, err
+	}
+	if rangeN == 0 {
+		return h.hash, nil
+	}
+	return h.hash % rangeN, nil
+}
+
+// The hash constants, hconst * n mod 2^32.
+const (
+	hconst   = 0x9e3779b9 // the golden ratio
+	hconst2  = 0x3c6ef372
+	hconst3  = 0xdaa66d2b
+	hconst4  = 0x78dde6e4
+	hconst5  = 0x1715609d
+	hconst6  = 0xb54cda56
+	hconst7  = 0x5384540f
+	hconst9  = 0x8ff34781
+	hconst10 = 0x2e2ac13a
+	hconst11 = 0xcc623af3
+	hconst12 = 0x6a99b4ac
+	hconst13 = 0x08d12e65
+	hconst14 = 0xa708a81e
+	hconst15 = 0x454021d7
+	hconst16 = 0xe3779b90
+	hconst19 = 0xbe1e08bb
+)
+
+// nilHash is the hash of [], when nothing comes before it.
+const nilHash = 3468870702
+
+// hash2 computes make_hash2 of erts, mixing each term into the hash of
+// those that came before it.
+type hash2 struct {
+	hash uint32
+}
+
+func (h *hash2) term(term Term) (err error) {
+	switch v := term.(type) {
+	case TermMarshaler:
+		var t Term
+		if t, err = v.MarshalTerm(); err == nil {
+			err = h.term(t)
+		}
+		return
+	case Marshaler:
+		var b []byte
+		var t Term
+		if b, err = v.MarshalETF(); err != nil {
+			return
+		}
+		if t, err = new(Context).Read(bytes.NewReader(b)); err == nil {
+			err = h.term(t)
+		}
+		return
+	case bool:
+		h.atom(Atom(atomText(v)))
+	case Atom:
+		h.atom(v)
+	case int8, int16, int32, int64, int:
+		h.integer(big.NewInt(reflect.ValueOf(v).Int()))
+	case uint8, uint16, uint32, uint64, uintptr, uint:
+		h.integer(new(big.Int).SetUint64(reflect.ValueOf(v).Uint()))
+	case *big.Int:
+		h.integer(v)
+	case float64:
+		h.float(v)
+	case float32:
+		h.float(float64(v))
+	case []byte:
+		h.binary(BitString{v, 8})
+	case BitString:
+		h.binary(v)
+	case string, Charlist, List, ImproperList:
+		return h.list(term)
+	case Tuple:
+		h.uint32(uint32(len(v)), hconst9)
+		for _, e := range v {
+			if err = h.term(e); err != nil {
+				return
+			}
+		}
+	case Map:
+		return h.hashMap(v)
+	case Pid:
+		h.uint32(v.Id, hconst5)
+	case Port:
+		// ids are 64 bits since OTP 24, hashed as low and high words
+		h.uint32x2(uint32(v.Id), uint32(v.Id>>32), hconst6)
+	case Ref:
+		var id uint32
+		if len(v.Id) > 0 {
+			id = v.Id[0]
+		}
+		h.uint32(id, hconst7)
+	case Export:
+		h.uint32x2(uint32(v.Arity), atomHash(v.Module), hconst)
+		h.uint32(atomHash(v.Function), hconst14)
+	case Function:
+		h.uint32x2(uint32(len(v.FreeVars)), atomHash(v.Module), hconst)
+		h.uint32x2(v.OldIndex, v.OldUnique, hconst)
+		for _, e := range v.FreeVars {
+			if err = h.term(e); err != nil {
+				return
+			}
+		}
+	default:
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Struct:
+			return h.term(structTerm(term))
+		case reflect.Array, reflect.Slice:
+			return h.list(term)
+		case reflect.Map:
+			return h.hashMap(new(Context).goMap(rv))
+		case reflect.Ptr:
+			if !rv.IsNil() {
+				return h.term(rv.Elem().Interface())
+			}
+		}
+		return &ErrUnknownType{reflect.TypeOf(term)}
+	}
+
+	return
+}
+
+// uint32x2 mixes x and y into the hash.
+func (h *hash2) uint32x2(x, y, k uint32) {
+	_, _, h.hash = mix(k+x, k+y, h.hash)
+}
+
+func (h *hash2) uint32(x, k uint32) {
+	h.uint32x2(x, 0, k)
+}
+
+func (h *hash2) atom(a Atom) {
+	if h.hash == 0 {
+		h.hash = atomHash(a)
+	} else {
+		h.uint32(atomHash(a), hconst3)
+	}
+}
+
+// integer hashes integers that fit in 28 bits as they are, and others
+// by their magnitude, 64 bits at a time, as erts does for bignums on
+// 64-bit machines.
+func (h *hash2) integer(i *big.Int) {
+	if i.IsInt64() && i.Int64() >= -1<<27 && i.Int64() < 1<<27 {
+		y := int32(i.Int64())
+		if y < 0 {
+			h.uint32(uint32(-y), hconst)
+		}
+		h.uint32(uint32(y), hconst)
+		return
+	}
+
+	k := uint32(hconst11)
+	if i.Sign() < 0 {
+		k = hconst10
+	}
+	b := new(big.Int).Abs(i).Bytes()
+	for n := len(b); n > 0; n -= 8 {
+		var x, y uint32
+		for j := n - 1; j >= 0 && j >= n-8; j-- {
+			if j >= n-4 {
+				x |= uint32(b[j]) << uint(8*(n-1-j))
+			} else {
+				y |= uint32(b[j]) << uint(8*(n-5-j))
+			}
+		}
+		h.uint32x2(x, y, k)
+	}
+}
+
+func (h *hash2) float(f float64) {
+	// -0.0 hashes as 0.0
+	var bits uint64
+	if f != 0 {
+		bits = math.Float64bits(f)
+	}
+	h.uint32x2(uint32(bits>>32), uint32(bits), hconst12)
+}
+
+func (h *hash2) binary(s BitString) {
+	k := hconst13 + h.hash
+	if len(s.Bytes) == 0 {
+		h.hash = k
+		return
+	}
+
+	full := s.Bytes
+	if s.Bits != 8 {
+		full = full[:len(full)-1]
+	}
+	h.hash = blockHash(full, k)
+	if s.Bits != 8 {
+		last := s.Bytes[len(s.Bytes)-1] >> (8 - s.Bits)
+		h.uint32x2(uint32(s.Bits), uint32(last), hconst15)
+	}
+}
+
+// list hashes a list, packing runs of characters 0 to 255 four to a
+// word, and then its tail.
+func (h *hash2) list(term Term) error {
+	var elems []Term
+	tail := term
+	for orderClass(tail) == orderList {
+		var e []Term
+		switch v := tail.(type) {
+		case string:
+			// as Write encodes it, a byte at a time
+			for i := 0; i < len(v); i++ {
+				e = append(e, int(v[i]))
+			}
+			tail = List{}
+		default:
+			e, tail = listParts(v)
+		}
+		elems = append(elems, e...)
+	}
+
+	for i := 0; i < len(elems); {
+		var word uint32
+		n := 0
+		for ; i < len(elems); i++ {
+			c, ok := byteValue(elems[i])
+			if !ok {
+				break
+			}
+			word = word<<8 + uint32(c)
+			if n++; n == 4 {
+				h.uint32(word, hconst4)
+				word, n = 0, 0
+			}
+		}
+		if n > 0 {
+			h.uint32(word, hconst4)
+		}
+
+		if i < len(elems) {
+			if err := h.term(elems[i]); err != nil {
+				return err
+			}
+			i++
+		}
+	}
+
+	if orderClass(tail) != orderNil {
+		return h.term(tail)
+	}
+	if h.hash == 0 {
+		h.hash = nilHash
+	} else {
+		h.uint32(2, hconst2)
+	}
+	return nil
+}
+
+// hashMap hashes the pairs of m each on their own and xors them, so
+// that their order doesn't matter.
+func (h *hash2) hashMap(m Map) error {
+	h.uint32(uint32(len(m)), hconst16)
+	if len(m) == 0 {
+		return nil
+	}
+
+	var pairs uint32
+	for _, e := range m {
+		var p hash2
+		if err := p.term(e.Key); err != nil {
+			return err
+		}
+		if err := p.term(e.Value); err != nil {
+			return err
+		}
+		pairs ^= p.hash
+	}
+	h.uint32(pairs, hconst19)
+	return nil
+}
+
+// byteValue returns an integer term that is a byte.
+func byteValue(t Term) (byte, bool) {
+	if orderClass(t) != orderNumber {
+		return 0, false
+	}
+	i, _, isFloat := number(t)
+	if isFloat || !i.IsInt64() || i.Int64() < 0 || i.Int64() > 255 {
+		return 0, false
+	}
+	return byte(i.Int64()), true
+}
+
+// atomHash returns the hash erts keeps for an atom in its atom table,
+// hashpjw of its UTF-8 name with characters below 256 as one byte.
+func atomHash(a Atom) uint32 {
+	var h uint32
+	for i := 0; i < len(a); i++ {
+		v := a[i]
+		if i+1 < len(a) && v&0xfe == 0xc2 && a[i+1]&0xc0 == 0x80 {
+			v = v<<6 | a[i+1]&0x3f
+			i++
+		}
+		h = h<<4 + uint32(v)
+		if g := h & 0xf0000000; g != 0 {
+			h ^= g >> 24
+			h ^= g
+		}
+	}
+	return h
+}
+
+// blockHash is Bob Jenkins' lookup2 hash of b, with initval k.
+func blockHash(b []byte, k uint32) uint32 {
+	a, bb, c := uint32(hconst), uint32(hconst), k
+	word := func(p []byte) uint32 {
+		return uint32(p[0]) | uint32(p[1])<<8 | uint32(p[2])<<16 | uint32(p[3])<<24
+	}
+
+	n := len(b)
+	for ; len(b) >= 12; b = b[12:] {
+		a += word(b)
+		bb += word(b[4:])
+		c += word(b[8:])
+		a, bb, c = mix(a, bb, c)
+	}
+
+	// the low byte of c is for the length
+	c += uint32(n)
+	var tail [12]byte
+	copy(tail[:], b)
+	a += word(tail[:])
+	bb += word(tail[4:])
+	c += word(tail[8:]) << 8
+	_, _, c = mix(a, bb, c)
+	return c
+}
+
+func mix(a, b, c uint32) (uint32, uint32, uint32) {
+	a -= b
+	a -= c
+	a ^= c >> 13
+	b -= c
+	b -= a
+	b ^= a << 8
+	c -= a
+	c -= b
+	c ^= b >> 13
+	a -= b
+	a -= c
+	a ^= c >> 12
+	b -= c
+	b -= a
+	b ^= a << 16
+	c -= a
+	c -= b
+	c ^= b >> 5
+	a -= b
+	a -= c
+	a ^= c >> 3
+	b -= c
+	b -= a
+	b ^= a << 10
+	c -= a
+	c -= b
+	c ^= b >> 15
+	return a, b, c
+}
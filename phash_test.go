@@ -0,0 +1,134 @@
+package etf
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"os"
+	"testing"
+)
+
+func TestPhash2Fixtures(t *testing.T) {
+	b, err := os.ReadFile("testdata/phash2.etf")
+	if os.IsNotExist(err) {
+		t.Fatal("no fixtures, run testdata/phash2.escript to make them")
+	} else if err != nil {
+		t.Fatal(err)
+	} else if len(b) == 0 || b[0] != EtVersion {
+		t.Fatal("testdata/phash2.etf is not in external term format")
+	}
+
+	table, err := new(Context).Read(bytes.NewReader(b[1:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range table.(List) {
+		row := row.(Tuple)
+		rangeN, _, _ := number(row[1])
+		exp, _, _ := number(row[2])
+		// 2^32 stands for 0
+		h, err := Phash2(row[0], uint32(rangeN.Uint64()))
+		if err != nil {
+			t.Errorf("%v: %v", row[0], err)
+		} else if uint64(h) != exp.Uint64() {
+			t.Errorf("phash2(%v, %v): expected %v, got %d", row[0], rangeN, exp, h)
+		}
+	}
+}
+
+func TestPhash2(t *testing.T) {
+	type point struct {
+		X, Y int
+	}
+	big1 := new(big.Int).Lsh(big.NewInt(1), 60)
+
+	// each pair of terms hashes the same
+	same := [][2]Term{
+		{1, int64(1)},
+		{uint8(200), big.NewInt(200)},
+		{int64(1) << 60, big1},
+		{-1.0, float32(-1.0)},
+		{0.0, math.Copysign(0, -1)},
+		{Atom("ok"), Atom("ok")},
+		{true, Atom("true")},
+		{"abc", List{97, 98, 99}},
+		{"été", List{195, 169, 116, 195, 169}},
+		{Charlist("été"), List{233, 116, 233}},
+		{"", List{}},
+		{[]int{}, List{}},
+		{[]int{1, 2}, List{1, 2}},
+		{ImproperList{List{1}, List{2, 3}}, List{1, 2, 3}},
+		{ImproperList{List{1}, ImproperList{List{2}, 3}}, ImproperList{List{1, 2}, 3}},
+		{[]byte("ab"), BitString{[]byte("ab"), 8}},
+		{point{1, 2}, Tuple{1, 2}},
+		{&point{1, 2}, Tuple{1, 2}},
+		{Map{{Atom("a"), 1}, {Atom("b"), 2}}, Map{{Atom("b"), 2}, {Atom("a"), 1}}},
+		{map[Atom]int{"a": 1, "b": 2}, Map{{Atom("a"), 1}, {Atom("b"), 2}}},
+		{Pid{Node: "a@b", Id: 7, Serial: 1}, Pid{Node: "c@d", Id: 7, Serial: 2}},
+	}
+	for _, p := range same {
+		h0, err0 := Phash2(p[0], 0)
+		h1, err1 := Phash2(p[1], 0)
+		if err0 != nil || err1 != nil {
+			t.Errorf("%v, %v: %v, %v", p[0], p[1], err0, err1)
+		} else if h0 != h1 {
+			t.Errorf("phash2(%v) = %d, phash2(%v) = %d", p[0], h0, p[1], h1)
+		}
+	}
+
+	// each term hashes differently from every other
+	terms := []Term{
+		0, 1, -1, 1 << 27, -1 << 27, big1, new(big.Int).Neg(big1), 1.0, 0.5,
+		Atom("a"), Atom("b"), List{}, List{Atom("a")}, "a", "ab", List{1, 2, 256},
+		ImproperList{List{1}, 2}, []byte{}, []byte{0}, BitString{[]byte{0x80}, 1},
+		Tuple{}, Tuple{1}, Tuple{List{}}, Map{}, Map{{1, 1}}, Map{{1, 1.0}},
+		Pid{Id: 1}, Port{Id: 1}, Port{Id: 1<<32 | 1}, Ref{Id: []uint32{1, 2, 3}},
+		Export{"lists", "map", 2}, Function{Module: "m", OldIndex: 1, OldUnique: 2},
+	}
+	seen := make(map[uint32]Term)
+	for _, term := range terms {
+		h, err := Phash2(term, 0)
+		if err != nil {
+			t.Errorf("%v: %v", term, err)
+		} else if prev, ok := seen[h]; ok {
+			t.Errorf("phash2(%v) = phash2(%v) = %d", term, prev, h)
+		}
+		seen[h] = term
+
+		for _, n := range []uint32{1, 2, 1000, 1 << 27} {
+			if r, _ := Phash2(term, n); r != h%n {
+				t.Errorf("phash2(%v, %d): expected %d, got %d", term, n, h%n, r)
+			}
+		}
+	}
+
+	if _, err := Phash2(nil, 0); err == nil {
+		t.Error("nil: err == nil")
+	}
+	if _, err := Phash2(Tuple{make(chan int)}, 0); err == nil {
+		t.Error("chan: err == nil")
+	}
+}
+
+func TestPhash2Constants(t *testing.T) {
+	consts := map[int]uint32{
+		2: hconst2, 3: hconst3, 4: hconst4, 5: hconst5, 6: hconst6, 7: hconst7,
+		9: hconst9, 10: hconst10, 11: hconst11, 12: hconst12, 13: hconst13,
+		14: hconst14, 15: hconst15, 16: hconst16, 19: hconst19,
+	}
+	for n, c := range consts {
+		if exp := uint32(hconst) * uint32(n); c != exp {
+			t.Errorf("hconst%d: expected %#x, got %#x", n, exp, c)
+		}
+	}
+
+	// erts takes a shortcut for [] on its own, the hash of NIL_DEF, 2
+	var h hash2
+	h.uint32(2, hconst2)
+	if h.hash != nilHash {
+		t.Errorf("nil: expected %d, got %d", nilHash, h.hash)
+	}
+	if h, _ := Phash2(List{}, 0); h != nilHash {
+		t.Errorf("[]: expected %d, got %d", nilHash, h)
+	}
+}
@@ -0,0 +1,501 @@
+package etf
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultWidth is the line width SprintPretty and %+v fill, like the
+// Erlang shell.
+const DefaultWidth = 80
+
+// Sprint returns term in Erlang syntax on a single line, as
+// io_lib:format("~0p") would write it: atoms are quoted if they need
+// to be, and lists of printable Latin-1 characters are written as
+// strings. It handles the Go types Write does, as what they encode to.
+// Pids, ports and references of nodes other than nonode@nohost have
+// the node name where the shell has a node number, as in <a@b.1.0>,
+// so that Parse reads them back, though without their creation. A nil
+// term, which Write can't encode, is written <nil>, as fmt writes it.
+func Sprint(term Term) string {
+	var b strings.Builder
+	printTerm(&b, term)
+	return b.String()
+}
+
+// SprintPretty returns term in Erlang syntax, as io_lib:format("~p")
+// would write it, breaking lines so they fit in width columns if they
+// can. Elements of tuples, lists and maps that don't fit on one line
+// are aligned one per line.
+func SprintPretty(term Term, width int) string {
+	var b strings.Builder
+	printPretty(&b, term, 0, width)
+	return b.String()
+}
+
+// formatTerm implements fmt.Formatter for the term types: %v and %s
+// write the term as Sprint does, %+v as SprintPretty does, with the
+// width, if any, as line width, and %q quotes what %v writes. %#v is
+// left to fmt. Atom only formats %v this way, leaving other verbs to
+// print the string it is.
+func formatTerm(f fmt.State, verb rune, term Term) {
+	switch {
+	case verb == 'v' && f.Flag('#'):
+		fmt.Fprintf(f, "%#v", plain(term))
+	case verb == 'v' && f.Flag('+'):
+		width, ok := f.Width()
+		if !ok {
+			width = DefaultWidth
+		}
+		fmt.Fprint(f, SprintPretty(term, width))
+	case verb == 'v', verb == 's':
+		fmt.Fprint(f, Sprint(term))
+	case verb == 'q':
+		fmt.Fprint(f, strconv.Quote(Sprint(term)))
+	default:
+		fmt.Fprintf(f, "%%!%c(%T=%s)", verb, term, Sprint(term))
+	}
+}
+
+func (t Tuple) Format(f fmt.State, verb rune)        { formatTerm(f, verb, t) }
+func (l List) Format(f fmt.State, verb rune)         { formatTerm(f, verb, l) }
+func (p Pid) Format(f fmt.State, verb rune)          { formatTerm(f, verb, p) }
+func (p Port) Format(f fmt.State, verb rune)         { formatTerm(f, verb, p) }
+func (r Ref) Format(f fmt.State, verb rune)          { formatTerm(f, verb, r) }
+func (fn Function) Format(f fmt.State, verb rune)    { formatTerm(f, verb, fn) }
+func (e Export) Format(f fmt.State, verb rune)       { formatTerm(f, verb, e) }
+func (m Map) Format(f fmt.State, verb rune)          { formatTerm(f, verb, m) }
+func (l ImproperList) Format(f fmt.State, verb rune) { formatTerm(f, verb, l) }
+func (s BitString) Format(f fmt.State, verb rune)    { formatTerm(f, verb, s) }
+func (l Charlist) Format(f fmt.State, verb rune)     { formatTerm(f, verb, l) }
+
+func (a Atom) Format(f fmt.State, verb rune) {
+	if verb == 'v' {
+		formatTerm(f, verb, a)
+	} else {
+		fmt.Fprintf(f, fmt.FormatString(f, verb), plain(a))
+	}
+}
+
+// Aliases for the fields of the types plain declares, which must keep
+// their types for conversions.
+type (
+	atom = Atom
+	list = List
+	pid  = Pid
+)
+
+// plain converts a term type, or a pointer to one, to a type of the same
+// name and structure without a Format method, for fmt to print with %#v.
+func plain(term Term) interface{} {
+	type (
+		Tuple    []Term
+		List     []Term
+		Atom     string
+		Map      []MapElem
+		Charlist []rune
+		Pid      struct {
+			Node                 atom
+			Id, Serial, Creation uint32
+		}
+		Port struct {
+			Node     atom
+			Id       uint64
+			Creation uint32
+		}
+		Ref struct {
+			Node     atom
+			Creation uint32
+			Id       []uint32
+		}
+		ImproperList struct {
+			Elems list
+			Tail  Term
+		}
+		BitString struct {
+			Bytes []byte
+			Bits  uint8
+		}
+		Export struct {
+			Module, Function atom
+			Arity            byte
+		}
+		Function struct {
+			Size      uint32
+			Arity     byte
+			Unique    [16]byte
+			Index     uint32
+			Free      uint32
+			Module    atom
+			OldIndex  uint32
+			OldUnique uint32
+			Pid       pid
+			FreeVars  []Term
+		}
+	)
+
+	rv := reflect.ValueOf(term)
+	if rv.Kind() == reflect.Ptr {
+		v := reflect.ValueOf(plain(rv.Elem().Interface()))
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		return p.Interface()
+	}
+
+	for _, t := range []interface{}{
+		Tuple{}, List{}, Atom(""), Map{}, Charlist{}, Pid{}, Port{}, Ref{},
+		ImproperList{}, BitString{}, Export{}, Function{},
+	} {
+		if t := reflect.TypeOf(t); t.Name() == rv.Type().Name() {
+			return rv.Convert(t).Interface()
+		}
+	}
+	return term
+}
+
+// printTerm writes term on a single line.
+func printTerm(b *strings.Builder, term Term) {
+	switch v := normalTerm(term).(type) {
+	case nil:
+		// not a term, so not something Parse reads back
+		b.WriteString("<nil>")
+	case bool:
+		b.WriteString(atomText(v))
+	case Atom:
+		b.WriteString(quoteAtom(string(v)))
+	case int:
+		b.WriteString(strconv.Itoa(v))
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	case uint64:
+		b.WriteString(strconv.FormatUint(v, 10))
+	case *big.Int:
+		b.WriteString(v.String())
+	case float64:
+		b.WriteString(formatFloat(v))
+	case []byte:
+		printBits(b, BitString{v, 8})
+	case BitString:
+		printBits(b, v)
+	case Pid:
+		fmt.Fprintf(b, "<%s.%d.%d>", nodeText(v.Node), v.Id, v.Serial)
+	case Port:
+		fmt.Fprintf(b, "#Port<%s.%d>", nodeText(v.Node), v.Id)
+	case Ref:
+		fmt.Fprintf(b, "#Ref<%s", nodeText(v.Node))
+		for i := len(v.Id) - 1; i >= 0; i-- {
+			fmt.Fprintf(b, ".%d", v.Id[i])
+		}
+		b.WriteByte('>')
+	case Function:
+		fmt.Fprintf(b, "#Fun<%s.%d.%d>", quoteAtom(string(v.Module)), v.OldIndex, v.OldUnique)
+	case Export:
+		fmt.Fprintf(b, "fun %s:%s/%d", quoteAtom(string(v.Module)), quoteAtom(string(v.Function)), v.Arity)
+	case Tuple:
+		b.WriteByte('{')
+		printElems(b, v)
+		b.WriteByte('}')
+	case Map:
+		b.WriteString("#{")
+		for i, e := range sortedMap(v) {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			printTerm(b, e.Key)
+			b.WriteString(" => ")
+			printTerm(b, e.Value)
+		}
+		b.WriteByte('}')
+	case ImproperList:
+		b.WriteByte('[')
+		printElems(b, v.Elems)
+		b.WriteByte('|')
+		printTerm(b, v.Tail)
+		b.WriteByte(']')
+	case List:
+		if s, ok := printable(v); ok {
+			b.WriteString(quoteString(s, '"'))
+			break
+		}
+		b.WriteByte('[')
+		printElems(b, v)
+		b.WriteByte(']')
+	default:
+		fmt.Fprint(b, v)
+	}
+}
+
+func printElems(b *strings.Builder, elems []Term) {
+	for i, e := range elems {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		printTerm(b, e)
+	}
+}
+
+// printPretty writes term starting at column col, breaking lines to
+// fit in width.
+func printPretty(b *strings.Builder, term Term, col, width int) {
+	term = normalTerm(term)
+	flat := Sprint(term)
+	if col+utf8.RuneCountInString(flat) <= width {
+		b.WriteString(flat)
+		return
+	}
+
+	switch v := term.(type) {
+	case Tuple:
+		// like records, tagged tuples keep the tag on the first line
+		if len(v) > 1 && orderClass(v[0]) == orderAtom {
+			printPrettyElems(b, "{"+Sprint(v[0])+",", v[1:], nil, "}", col, width)
+			return
+		}
+		printPrettyElems(b, "{", v, nil, "}", col, width)
+	case ImproperList:
+		printPrettyElems(b, "[", v.Elems, v.Tail, "]", col, width)
+	case List:
+		if _, ok := printable(v); ok {
+			b.WriteString(flat)
+			return
+		}
+		printPrettyElems(b, "[", v, nil, "]", col, width)
+	case Map:
+		b.WriteString("#{")
+		col += 2
+		for i, e := range sortedMap(v) {
+			if i > 0 {
+				b.WriteString(",\n")
+				b.WriteString(strings.Repeat(" ", col))
+			}
+			key := Sprint(e.Key)
+			b.WriteString(key)
+			b.WriteString(" => ")
+			printPretty(b, e.Value, col+utf8.RuneCountInString(key)+4, width)
+		}
+		b.WriteByte('}')
+	default:
+		b.WriteString(flat)
+	}
+}
+
+func printPrettyElems(b *strings.Builder, open string, elems []Term, tail Term, close string, col, width int) {
+	b.WriteString(open)
+	col += utf8.RuneCountInString(open)
+	for i, e := range elems {
+		if i > 0 {
+			b.WriteString(",\n")
+			b.WriteString(strings.Repeat(" ", col))
+		}
+		printPretty(b, e, col, width)
+	}
+	if tail != nil {
+		b.WriteString("|\n")
+		b.WriteString(strings.Repeat(" ", col))
+		printPretty(b, tail, col, width)
+	}
+	b.WriteString(close)
+}
+
+// normalTerm returns the term that Go values are encoded as, with
+// integers as int, int64, uint64 or *big.Int, and strings and other
+// lists as List.
+func normalTerm(term Term) Term {
+	switch v := term.(type) {
+	case nil, bool, Atom, int, int64, *big.Int, float64, []byte, BitString,
+		Pid, Port, Ref, Function, Export, Tuple, Map, ImproperList, List:
+		return v
+	case string:
+		// the list of its bytes, as Write encodes it
+		l := make(List, len(v))
+		for i := 0; i < len(v); i++ {
+			l[i] = int(v[i])
+		}
+		return l
+	case Charlist:
+		l := make(List, len(v))
+		for i, r := range v {
+			l[i] = int(r)
+		}
+		return l
+	case float32:
+		return float64(v)
+	case int8, int16, int32:
+		return int(reflect.ValueOf(v).Int())
+	case uint8, uint16, uint32, uint64, uint, uintptr:
+		return reflect.ValueOf(v).Uint()
+	}
+
+	rv := reflect.ValueOf(term)
+	switch rv.Kind() {
+	case reflect.Struct:
+		return structTerm(term)
+	case reflect.Array, reflect.Slice:
+		l := make(List, rv.Len())
+		for i := range l {
+			l[i] = rv.Index(i).Interface()
+		}
+		return l
+	case reflect.Map:
+		return new(Context).goMap(rv)
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+		return normalTerm(rv.Elem().Interface())
+	}
+	return term
+}
+
+// printable returns the string a list is if it only holds printable
+// Latin-1 characters, as io_lib:printable_list/1 does.
+func printable(l List) (string, bool) {
+	if len(l) == 0 {
+		return "", false
+	}
+	r := make([]rune, len(l))
+	for i, e := range l {
+		c, ok := e.(int)
+		if !ok || !(c >= 32 && c <= 126 || c >= 160 && c <= 255 || strings.ContainsRune("\n\r\t\v\b\f\x1b", rune(c))) {
+			return "", false
+		}
+		r[i] = rune(c)
+	}
+	return string(r), true
+}
+
+// printBits writes a binary or bitstring, with its printable prefix
+// as a string.
+func printBits(b *strings.Builder, s BitString) {
+	full := s.Bytes
+	if s.Bits != 8 && len(full) > 0 {
+		full = full[:len(full)-1]
+	}
+
+	b.WriteString("<<")
+	l := make(List, len(full))
+	for i, c := range full {
+		l[i] = int(c)
+	}
+	if str, ok := printable(l); ok {
+		b.WriteString(quoteString(str, '"'))
+	} else {
+		for i, c := range full {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(strconv.Itoa(int(c)))
+		}
+	}
+	if len(full) < len(s.Bytes) {
+		if len(full) > 0 {
+			b.WriteByte(',')
+		}
+		last := s.Bytes[len(s.Bytes)-1] >> (8 - s.Bits)
+		fmt.Fprintf(b, "%d:%d", last, s.Bits)
+	}
+	b.WriteString(">>")
+}
+
+// nodeText returns what stands for node in pids, ports and references:
+// 0 for the local node, as the Erlang shell prints them, or its name,
+// which the shell can't know and Parse accepts instead.
+func nodeText(node Atom) string {
+	if node == localNode {
+		return "0"
+	}
+	return quoteAtom(string(node))
+}
+
+// quoteAtom returns the atom name as written in Erlang, quoted if it
+// needs to be.
+func quoteAtom(name string) string {
+	if name == "" || reservedWords[name] {
+		return quoteString(name, '\'')
+	}
+	for i, r := range name {
+		lower := r >= 'a' && r <= 'z' || r >= 0xdf && r <= 0xff && r != 0xf7
+		switch {
+		case lower:
+		case i > 0 && (r >= 'A' && r <= 'Z' || r >= 0xc0 && r <= 0xde && r != 0xd7 ||
+			r >= '0' && r <= '9' || r == '_' || r == '@'):
+		default:
+			return quoteString(name, '\'')
+		}
+	}
+	return name
+}
+
+var quoteEscapes = map[rune]string{
+	'\n': `\n`, '\r': `\r`, '\t': `\t`, '\v': `\v`, '\b': `\b`, '\f': `\f`,
+	0x1b: `\e`, 0x7f: `\d`, '\\': `\\`,
+}
+
+// quoteString quotes s with q, escaping as Erlang does.
+func quoteString(s string, q rune) string {
+	var b strings.Builder
+	b.WriteRune(q)
+	for _, r := range s {
+		switch e, ok := quoteEscapes[r]; {
+		case ok:
+			b.WriteString(e)
+		case r == q:
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32:
+			fmt.Fprintf(&b, `\^%c`, r+64)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteRune(q)
+	return b.String()
+}
+
+// formatFloat formats f with the shortest digits that read back as f,
+// placing the decimal point as io_lib_format:fwrite_g/1 does.
+func formatFloat(f float64) string {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+
+	sign := ""
+	if math.Signbit(f) {
+		sign, f = "-", -f
+	}
+
+	// d.ddde±x is 0.dddd × 10^place
+	e := strconv.FormatFloat(f, 'e', -1, 64)
+	mant, exp := e[:strings.IndexByte(e, 'e')], e[strings.IndexByte(e, 'e')+1:]
+	digits := strings.Replace(mant, ".", "", 1)
+	x, _ := strconv.Atoi(exp)
+	place := x + 1
+	n := len(digits)
+
+	if place <= 0 || place >= n {
+		expText := strconv.Itoa(place - 1)
+		expDot := 1
+		if n == 1 {
+			expDot = 2
+		}
+		cost := len(expText) + 1 + expDot
+		switch {
+		case place == 0:
+			return sign + "0." + digits
+		case place < 0 && 2-place <= cost:
+			return sign + "0." + strings.Repeat("0", -place) + digits
+		case place > 0 && place-n+2 <= cost:
+			return sign + digits + strings.Repeat("0", place-n) + ".0"
+		case n == 1:
+			return sign + digits + ".0e" + expText
+		}
+		return sign + digits[:1] + "." + digits[1:] + "e" + expText
+	}
+
+	return sign + digits[:place] + "." + digits[place:]
+}
@@ -0,0 +1,194 @@
+package etf
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSprint(t *testing.T) {
+	big1, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	type point struct {
+		X, Y int
+	}
+
+	cases := []struct {
+		in  Term
+		exp string
+	}{
+		{Atom("ok"), `ok`},
+		{Atom("node@host_1"), `node@host_1`},
+		{Atom("été"), `été`},
+		{Atom("Ok"), `'Ok'`},
+		{Atom("hello world"), `'hello world'`},
+		{Atom(""), `''`},
+		{Atom("it's\n"), `'it\'s\n'`},
+		{Atom("end"), `'end'`},
+		{Atom("€"), `'€'`},
+		{true, `true`},
+		{nil, `<nil>`},
+		{42, `42`},
+		{int8(-1), `-1`},
+		{uint64(1 << 63), `9223372036854775808`},
+		{big1, `-123456789012345678901234567890`},
+		{0.0, `0.0`},
+		{2.5, `2.5`},
+		{-0.1, `-0.1`},
+		{100.0, `100.0`},
+		{1000.0, `1.0e3`},
+		{1.5e10, `1.5e10`},
+		{0.001, `0.001`},
+		{1e-5, `1.0e-5`},
+		{float32(0.5), `0.5`},
+		{"hello", `"hello"`},
+		{"a\"b\\\t", `"a\"b\\\t"`},
+		{"", `[]`},
+		{"€", `[226,130,172]`},
+		{"é", `"Ã©"`},
+		{"\xe9", `"é"`},
+		{Charlist("été"), `"été"`},
+		{List{}, `[]`},
+		{List{104, 105}, `"hi"`},
+		{List{1, Atom("a"), List{}}, `[1,a,[]]`},
+		{ImproperList{List{1, 2}, Atom("b")}, `[1,2|b]`},
+		{[]int{1, 2}, `[1,2]`},
+		{[]byte{}, `<<>>`},
+		{[]byte("text"), `<<"text">>`},
+		{[]byte{1, 2, 255}, `<<1,2,255>>`},
+		{BitString{[]byte{'a', 'b', 0x30}, 4}, `<<"ab",3:4>>`},
+		{BitString{[]byte{0xa0}, 3}, `<<5:3>>`},
+		{Tuple{}, `{}`},
+		{Tuple{Atom("ok"), "x", Tuple{1}}, `{ok,"x",{1}}`},
+		{point{1, 2}, `{1,2}`},
+		{&point{1, 2}, `{1,2}`},
+		{Map{{Atom("b"), 2}, {Atom("a"), 1}}, `#{a => 1,b => 2}`},
+		{map[string]int{"b": 2, "a": 1}, `#{"a" => 1,"b" => 2}`},
+		{Pid{Node: "nonode@nohost", Id: 42}, `<0.42.0>`},
+		{Pid{Node: "a@b", Id: 1, Serial: 2}, `<a@b.1.2>`},
+		{Ref{Node: "nonode@nohost", Id: []uint32{1, 2, 3}}, `#Ref<0.3.2.1>`},
+		{Port{Node: "nonode@nohost", Id: 5}, `#Port<0.5>`},
+		{Function{Module: "erl_eval", OldIndex: 6, OldUnique: 128}, `#Fun<erl_eval.6.128>`},
+		{Export{"lists", "map", 2}, `fun lists:map/2`},
+		{Export{"a b", "if", 0}, `fun 'a b':'if'/0`},
+	}
+
+	for _, tc := range cases {
+		if s := Sprint(tc.in); s != tc.exp {
+			t.Errorf("%#v: expected %s, got %s", tc.in, tc.exp, s)
+		}
+	}
+}
+
+func TestSprintParse(t *testing.T) {
+	for _, in := range []string{
+		`{ok,[1,2,<<"x">>],'a b',"str",#{a => [x|y]}}`,
+		`[<0.1.2>,#Ref<0.1.2.3>,#Port<0.4>,fun m:f/1]`,
+		`<<1,2,3:4>>`,
+		`[<a@b.1.2>,<'a@b.c'.3.0>,#Ref<a@b.1.2.3>,#Port<a@b.4294967296>]`,
+	} {
+		term, err := Parse(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Parse returns lists of characters as strings
+		if s := Sprint(term); s != in {
+			t.Errorf("expected %s, got %s", in, s)
+		}
+	}
+
+	// strings are the lists of their bytes, as Write encodes them
+	for _, in := range []string{"été", "日本", "\xe9t\xe9"} {
+		s := Sprint(in)
+		if term, err := Parse(s); err != nil {
+			t.Errorf("%s: %v", s, err)
+		} else if !ExactEqual(term, in) {
+			t.Errorf("%s: expected %q, got %#v", s, in, term)
+		}
+	}
+
+	// remote identifiers name their node
+	for _, in := range []Term{
+		Pid{Node: "a@b", Id: 1, Serial: 2},
+		Port{Node: "n@host.example.com", Id: 7},
+		Ref{Node: "a@b", Id: []uint32{1, 2, 3}},
+	} {
+		s := Sprint(in)
+		if term, err := Parse(s); err != nil {
+			t.Errorf("%s: %v", s, err)
+		} else if !reflect.DeepEqual(term, in) {
+			t.Errorf("%s: expected %#v, got %#v", s, in, term)
+		}
+	}
+}
+
+func TestSprintPretty(t *testing.T) {
+	term := Tuple{
+		Atom("config"),
+		List{
+			Tuple{Atom("name"), "a rather long application name"},
+			Tuple{Atom("nodes"), List{Atom("alpha@host"), Atom("beta@host")}},
+		},
+		Map{{Atom("opts"), List{1, 2, 3}}, {Atom("k"), Tuple{Atom("v")}}},
+	}
+
+	exp := strings.Join([]string{
+		`{config,[{name,"a rather long application name"},`,
+		`         {nodes,[alpha@host,beta@host]}],`,
+		`        #{k => {v},opts => [1,2,3]}}`,
+	}, "\n")
+	if s := SprintPretty(term, 60); s != exp {
+		t.Errorf("expected\n%s\ngot\n%s", exp, s)
+	}
+
+	exp = strings.Join([]string{
+		`#{key => [aaaaaaaaaa,`,
+		`          bbbbbbbbbb]}`,
+	}, "\n")
+	if s := SprintPretty(Map{{Atom("key"), List{Atom("aaaaaaaaaa"), Atom("bbbbbbbbbb")}}}, 24); s != exp {
+		t.Errorf("expected\n%s\ngot\n%s", exp, s)
+	}
+
+	if s := SprintPretty(Tuple{1, 2}, 1); s != "{1,\n 2}" {
+		t.Errorf("got %q", s)
+	}
+	if s := SprintPretty(Tuple{}, 1); s != "{}" {
+		t.Errorf("got %q", s)
+	}
+	if s := SprintPretty(Tuple{1, 2}, DefaultWidth); s != "{1,2}" {
+		t.Errorf("got %q", s)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	term := Tuple{Atom("ok"), List{1, 2}}
+	cases := []struct {
+		format string
+		in     interface{}
+		exp    string
+	}{
+		{"%v", term, `{ok,[1,2]}`},
+		{"%s", term, `{ok,[1,2]}`},
+		{"%q", Tuple{Atom("a b")}, `"{'a b'}"`},
+		{"%v", Atom("Hello"), `'Hello'`},
+		{"%+v", Atom("Hello"), `'Hello'`},
+		{"%s", Atom("Hello"), `Hello`},
+		{"%q", Atom("a b"), `"a b"`},
+		{"%5s", Atom("a"), `    a`},
+		{"%+v", term, `{ok,[1,2]}`},
+		{"%+4v", term, "{ok,[1,\n     2]}"},
+		{"%v", []Term{Atom("a"), "b"}, `[a b]`},
+		{"%v", Pid{Node: "nonode@nohost", Id: 1}, `<0.1.0>`},
+		{"%d", Atom("a"), `%!d(etf.Atom=a)`},
+		{"%#v", Atom("a"), `"a"`},
+		{"%#v", Tuple{Atom("a"), 1}, `etf.Tuple{"a", 1}`},
+		{"%#v", Export{"m", "f", 1}, `etf.Export{Module:"m", Function:"f", Arity:0x1}`},
+	}
+
+	for _, tc := range cases {
+		if s := fmt.Sprintf(tc.format, tc.in); s != tc.exp {
+			t.Errorf("%s: expected %s, got %s", tc.format, tc.exp, s)
+		}
+	}
+}
@@ -2,11 +2,14 @@ package etf
 
 import (
 	"bytes"
+	"compress/zlib"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"math"
 	"math/big"
+	"strings"
+	"unicode/utf8"
 )
 
 type ErrUnknownTerm struct {
@@ -14,10 +17,10 @@ type ErrUnknownTerm struct {
 }
 
 var (
-	ErrFloatScan = fmt.Errorf("read: failed to sscanf float")
-	be           = binary.BigEndian
-	bTrue        = []byte("true")
-	bFalse       = []byte("false")
+	ErrFloatScan      = fmt.Errorf("read: failed to sscanf float")
+	ErrCompressedSize = fmt.Errorf("read: compressed term size mismatch")
+	ErrCacheRef       = fmt.Errorf("read: atom cache reference not in distribution header")
+	be                = binary.BigEndian
 )
 
 func (c *Context) ReadDist(r io.Reader) (err error) {
@@ -39,6 +42,9 @@ func (c *Context) ReadDist(r io.Reader) (err error) {
 
 	refsNum := int(b[0])
 	if refsNum > 0 {
+		if c.atomCache == nil {
+			c.atomCache = new([atomCacheSize]*string)
+		}
 		b = make([]byte, (refsNum/2)+1)
 		_, err = io.ReadFull(r, b)
 		if err != nil {
@@ -91,7 +97,7 @@ func (c *Context) ReadDist(r io.Reader) (err error) {
 				if err != nil {
 					return
 				}
-				strText := string(b)
+				strText := string(c.atomName(nil, b))
 				currentAtomCache[i] = &strText
 
 				cIdx := ((uint16(flags[i].segmentIdx) << 8) | uint16(intRef))
@@ -114,46 +120,250 @@ func (c *Context) ReadDist(r io.Reader) (err error) {
 	return
 }
 
+// Unmarshal decodes data, as produced by erlang:term_to_binary, and
+// stores the result in the value pointed to by v as DecodeTerm does.
+func Unmarshal(data []byte, v interface{}) (err error) {
+	if len(data) == 0 {
+		return io.ErrUnexpectedEOF
+	} else if data[0] != EtVersion {
+		return fmt.Errorf("read: version %d not supported", data[0])
+	}
+
+	if u, ok := v.(Unmarshaler); ok {
+		return u.UnmarshalETF(data[1:])
+	}
+
+	r := bytes.NewReader(data[1:])
+	var term Term
+	if term, err = new(Context).Read(r); err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return
+	} else if r.Len() != 0 {
+		return fmt.Errorf("read: %d bytes left after term", r.Len())
+	}
+
+	return DecodeTerm(term, v)
+}
+
+// Read decodes a term from r. Compressed terms are decompressed
+// transparently. Read returns io.EOF if r is at its end, and an
+// *ErrSyntax for malformed input.
 func (c *Context) Read(r io.Reader) (term Term, err error) {
+	rd := newReader(r, &c.Limits)
+	if err = rd.enter(); err != nil {
+		return nil, rd.fail("", err)
+	}
 	var etype byte
-	if etype, err = ruint8(r); err != nil {
-		return nil, err
+	if etype, err = rd.ReadByte(); err == io.EOF && rd.n == 0 {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, rd.fail("", err)
+	}
+	if etype == ettCompressed {
+		term, err = c.readCompressed(rd)
+	} else {
+		term, err = c.readTerm(rd, etype)
+	}
+	if err != nil {
+		return nil, rd.fail(tagName(etype), err)
+	}
+	return
+}
+
+// ReadBytes decodes the term at the start of b, which holds no version
+// byte, and returns it with the number of bytes it takes up. It is Read
+// for terms already in memory, and it doesn't copy them: binaries and
+// bitstrings it returns are slices of b, which must not change while
+// they are in use. Atoms and strings are copied unless UnsafeStrings is
+// set. Terms in a compressed term don't share memory with b.
+func (c *Context) ReadBytes(b []byte) (term Term, n int, err error) {
+	rd := newBufReader(b, &c.Limits, c.UnsafeStrings)
+	if len(b) == 0 {
+		return nil, 0, io.EOF
+	} else if err = rd.enter(); err != nil {
+		return nil, 0, rd.fail("", err)
+	}
+
+	var etype byte
+	if etype, err = rd.ReadByte(); err != nil {
+		return nil, 0, rd.fail("", err)
+	}
+	if etype == ettCompressed {
+		term, err = c.readCompressed(rd)
+	} else {
+		term, err = c.readTerm(rd, etype)
+	}
+	if err != nil {
+		return nil, 0, rd.fail(tagName(etype), err)
+	}
+	return term, int(rd.n), nil
+}
+
+func (c *Context) readCompressed(r *reader) (term Term, err error) {
+	// $PSSSSZ…
+	var size uint32
+	if size, err = r.uint32(); err != nil {
+		return
+	} else if max := c.Limits.MaxBytes; max > 0 && int64(size) > max {
+		return nil, &ErrLimit{"MaxBytes", int64(size)}
+	}
+
+	// r is an io.ByteReader, which keeps zlib from reading ahead
+	// into whatever follows the compressed term
+	var zr io.ReadCloser
+	if zr, err = zlib.NewReader(r); err != nil {
+		return
+	}
+	defer zr.Close()
+
+	buf := new(bytes.Buffer)
+	if _, err = io.CopyN(buf, zr, int64(size)); err == io.EOF {
+		return nil, ErrCompressedSize
+	} else if err != nil {
+		return
+	}
+	if n, e := zr.Read([]byte{0}); n != 0 {
+		return nil, ErrCompressedSize
+	} else if e != io.EOF {
+		return nil, e
+	}
+
+	// nothing else holds buf, so terms can share its memory
+	rd := newBufReader(buf.Bytes(), &c.Limits, true)
+	if term, err = c.read(rd); err == nil && rd.n != int64(buf.Len()) {
+		err = ErrCompressedSize
+	}
+	return
+}
+
+func (c *Context) read(r *reader) (term Term, err error) {
+	if err = r.enter(); err != nil {
+		return nil, r.fail("", err)
+	}
+	defer r.leave()
+
+	var etype byte
+	if etype, err = r.ReadByte(); err != nil {
+		return nil, r.fail("", err)
+	}
+	if term, err = c.readTerm(r, etype); err != nil {
+		return nil, r.fail(tagName(etype), err)
+	}
+	return
+}
+
+// readAt reads the nested term at index i of a term of type tag.
+func (c *Context) readAt(r *reader, tag byte, i int) (term Term, err error) {
+	r.path = append(r.path, step{tag, i})
+	term, err = c.read(r)
+	r.path = r.path[:len(r.path)-1]
+	return
+}
+
+// readAtom reads a nested term that must be an atom.
+func (c *Context) readAtom(r *reader, tag byte, i int) (Atom, error) {
+	t, err := c.readAt(r, tag, i)
+	if err != nil {
+		return "", err
+	}
+	switch a := t.(type) {
+	case Atom:
+		return a, nil
+	case bool:
+		return Atom(atomText(a)), nil
+	}
+	return "", r.fail(tagName(tag), fmt.Errorf("%T where an atom is expected", t))
+}
+
+// readUint32 reads a nested term that must be a non-negative
+// integer fitting in 32 bits.
+func (c *Context) readUint32(r *reader, tag byte, i int) (uint32, error) {
+	t, err := c.readAt(r, tag, i)
+	if err != nil {
+		return 0, err
+	}
+	if x, ok := t.(int); ok && x >= 0 && int64(x) <= math.MaxUint32 {
+		return uint32(x), nil
+	}
+	return 0, r.fail(tagName(tag), fmt.Errorf("%v where a 32-bit unsigned integer is expected", t))
+}
+
+// readPid reads a nested term that must be a pid.
+func (c *Context) readPid(r *reader, tag byte, i int) (Pid, error) {
+	t, err := c.readAt(r, tag, i)
+	if err != nil {
+		return Pid{}, err
 	}
+	if pid, ok := t.(Pid); ok {
+		return pid, nil
+	}
+	return Pid{}, r.fail(tagName(tag), fmt.Errorf("%T where a pid is expected", t))
+}
+
+func (c *Context) readTerm(r *reader, etype byte) (term Term, err error) {
 	var b []byte
+	var size uint32
 
 	switch etype {
-	case ettAtom, ettAtomUTF8:
-		// $dLL… | $vLL…
-		if b, err = buint16(r); err == nil {
-			_, err = io.ReadFull(r, b)
-			term = newAtom(b)
+	case ettAtom, ettAtomUTF8, ettSmallAtom, ettSmallAtomUTF8:
+		// $dLL… | $vLL… | $sL… | $wL…
+		if etype == ettAtom || etype == ettAtomUTF8 {
+			var n uint16
+			n, err = r.uint16()
+			size = uint32(n)
+		} else {
+			var n uint8
+			n, err = r.ReadByte()
+			size = uint32(n)
 		}
-
-	case ettSmallAtom, ettSmallAtomUTF8:
-		// $sL…, $wL…
-		if b, err = buint8(r); err == nil {
-			_, err = io.ReadFull(r, b)
-			term = newAtom(b)
+		if err != nil {
+			break
+		} else if b, err = r.bytes(size); err != nil {
+			break
+		}
+		if etype == ettAtom || etype == ettSmallAtom {
+			b = fromLatin1(b)
+		} else if !utf8.Valid(b) {
+			err = fmt.Errorf("atom is not valid UTF-8 (%q)", b)
+			break
 		}
+		term = newAtom(c.atomName(r, b))
 
 	case ettBinary:
 		// $mLLLL…
-		if b, err = buint32(r); err == nil {
-			_, err = io.ReadFull(r, b)
+		if size, err = r.uint32(); err != nil {
+			break
+		} else if err = r.check("MaxBinary", c.Limits.MaxBinary, size); err != nil {
+			break
+		} else if b, err = r.bytes(size); err == nil {
 			term = b
 		}
 
 	case ettString:
 		// $kLL…
-		if b, err = buint16(r); err == nil {
-			_, err = io.ReadFull(r, b)
-			term = string(b)
+		var n uint16
+		if n, err = r.uint16(); err != nil {
+			break
+		} else if b, err = r.bytes(uint32(n)); err != nil {
+			break
+		}
+		switch c.Charlists {
+		case CharlistsAsCharlist:
+			l := make(Charlist, len(b))
+			for i := range b {
+				l[i] = rune(b[i])
+			}
+			term = l
+		case CharlistsAsString:
+			term = r.text(fromLatin1(b))
+		default:
+			term = r.text(b)
 		}
 
 	case ettFloat:
 		// $cFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF0
-		b = make([]byte, 31)
-		if _, err = io.ReadFull(r, b); err != nil {
+		if b, err = r.bytes(31); err != nil {
 			return
 		}
 		var r int
@@ -165,240 +375,282 @@ func (c *Context) Read(r io.Reader) (term Term, err error) {
 
 	case ettNewFloat:
 		// $FFFFFFFFF
-		b = make([]byte, 8)
-		if _, err = io.ReadFull(r, b); err == nil {
-			term = math.Float64frombits(be.Uint64(b))
+		var x uint64
+		if x, err = r.uint64(); err == nil {
+			term = math.Float64frombits(x)
 		}
 
 	case ettSmallInteger:
 		// $aI
 		var x uint8
-		x, err = ruint8(r)
+		x, err = r.ReadByte()
 		term = int(x)
 
 	case ettInteger:
 		// $bIIII
-		var x int32
-		err = binary.Read(r, be, &x)
-		term = int(x)
+		var x uint32
+		x, err = r.uint32()
+		term = int(int32(x))
 
 	case ettSmallBig:
 		// $nAS…
-		b = make([]byte, 2)
-		if _, err = io.ReadFull(r, b); err != nil {
+		if b, err = r.fixed(2); err != nil {
 			break
 		}
 		sign := b[1]
-		b = make([]byte, b[0])
-		term, err = readBigInt(r, b, sign)
+		if err = r.check("MaxBigInt", c.Limits.MaxBigInt, uint32(b[0])); err != nil {
+			break
+		}
+		term, err = readBigInt(r, uint32(b[0]), sign)
 
 	case ettLargeBig:
 		// $oAAAAS…
-		b = make([]byte, 5)
-		if _, err = io.ReadFull(r, b); err != nil {
+		if b, err = r.fixed(5); err != nil {
 			break
 		}
 		sign := b[4]
-		b = make([]byte, be.Uint32(b[:4]))
-		term, err = readBigInt(r, b, sign)
+		size = be.Uint32(b[:4])
+		if err = r.check("MaxBigInt", c.Limits.MaxBigInt, size); err != nil {
+			break
+		}
+		term, err = readBigInt(r, size, sign)
 
 	case ettNil:
 		// $j
 		term = List{}
 
-	case ettPid:
-		var node interface{}
+	case ettPid, ettNewPid:
+		// $g…IIIISSSSC | $X…IIIISSSSCCCC
 		var pid Pid
-		b = make([]byte, 9)
-		if node, err = c.Read(r); err != nil {
+		if pid.Node, err = c.readAtom(r, etype, 0); err != nil {
+			return
+		} else if pid.Id, err = r.uint32(); err != nil {
+			return
+		} else if pid.Serial, err = r.uint32(); err != nil {
 			return
-		} else if _, err = io.ReadFull(r, b); err != nil {
+		} else if pid.Creation, err = r.creation(etype == ettPid); err != nil {
 			return
 		}
-		pid.Node = node.(Atom)
-		pid.Id = be.Uint32(b[:4])
-		pid.Serial = be.Uint32(b[4:8])
-		pid.Creation = b[8]
 		term = pid
 
-	case ettNewRef:
-		// $rLL…
+	case ettNewRef, ettNewerRef:
+		// $rLL…C… | $ZLL…CCCC…
 		var ref Ref
-		var node interface{}
 		var nid uint16
-		if nid, err = ruint16(r); err != nil {
+		if nid, err = r.uint16(); err != nil {
 			return
-		} else if node, err = c.Read(r); err != nil {
+		} else if ref.Node, err = c.readAtom(r, etype, 0); err != nil {
 			return
-		} else if ref.Creation, err = ruint8(r); err != nil {
+		} else if ref.Creation, err = r.creation(etype == ettNewRef); err != nil {
 			return
 		}
-		ref.Node = node.(Atom)
-		ref.Id = make([]uint32, nid)
-		for i := 0; i < cap(ref.Id); i++ {
-			if ref.Id[i], err = ruint32(r); err != nil {
+		var n int
+		if n, err = r.elements(uint32(nid)); err != nil {
+			return
+		}
+		ref.Id = make([]uint32, 0, n)
+		for i := 0; i < int(nid); i++ {
+			var id uint32
+			if id, err = r.uint32(); err != nil {
 				return
 			}
+			ref.Id = append(ref.Id, id)
 		}
 		term = ref
 
 	case ettRef:
-		// $e…LLLLB
+		// $e…LLLLC
 		var ref Ref
-		var node interface{}
-		if node, err = c.Read(r); err != nil {
+		if ref.Node, err = c.readAtom(r, etype, 0); err != nil {
 			return
 		}
-		ref.Node = node.(Atom)
 		ref.Id = make([]uint32, 1)
-		if ref.Id[0], err = ruint32(r); err != nil {
+		if ref.Id[0], err = r.uint32(); err != nil {
 			return
-		} else if _, err = io.ReadFull(r, b); err != nil {
+		} else if ref.Creation, err = r.creation(true); err != nil {
 			return
 		}
-		ref.Creation = b[0]
 		term = ref
 
-	case ettSmallTuple:
-		// $hA…
-		var arity uint8
-		if arity, err = ruint8(r); err != nil {
-			break
-		}
-		tuple := make(Tuple, arity)
-		for i := 0; i < cap(tuple); i++ {
-			if tuple[i], err = c.Read(r); err != nil {
-				break
-			}
-		}
-		term = tuple
-
-	case ettLargeTuple:
-		// $iAAAA…
+	case ettSmallTuple, ettLargeTuple:
+		// $hA… | $iAAAA…
 		var arity uint32
-		if arity, err = ruint32(r); err != nil {
+		if etype == ettSmallTuple {
+			var a uint8
+			a, err = r.ReadByte()
+			arity = uint32(a)
+		} else {
+			arity, err = r.uint32()
+		}
+		if err != nil {
 			break
 		}
-		tuple := make(Tuple, arity)
-		for i := 0; i < cap(tuple); i++ {
-			if tuple[i], err = c.Read(r); err != nil {
-				break
-			}
+		var tuple Tuple
+		if tuple, err = c.readElements(r, etype, 0, arity); err == nil {
+			term = tuple
 		}
-		term = tuple
 
 	case ettList:
 		// $lLLLL…$j
 		var n uint32
-		if n, err = ruint32(r); err != nil {
+		if n, err = r.uint32(); err != nil {
 			return
 		}
 
-		list := make(List, n+1)
-		for i := 0; i < cap(list); i++ {
-			if list[i], err = c.Read(r); err != nil {
-				return
-			}
+		var list List
+		if list, err = c.readElements(r, etype, 0, n); err != nil {
+			return
+		}
+		var tail Term
+		if tail, err = c.readAt(r, etype, int(n)); err != nil {
+			return
 		}
 
-		switch list[n].(type) {
-		case List:
-			// proper list, remove nil element
-			list = list[:n]
+		if l, ok := tail.(List); ok {
+			// proper list, the tail is normally nil
+			term = c.string(append(list, l...))
+		} else if n == 0 {
+			term = tail
+		} else {
+			term = ImproperList{list, tail}
 		}
-		term = list
+
+	case ettMap:
+		// $tAAAA…
+		var arity uint32
+		if arity, err = r.uint32(); err != nil {
+			break
+		}
+		var n int
+		if n, err = r.elements(arity); err != nil {
+			break
+		}
+		m := make(Map, 0, n)
+		for i := 0; i < int(arity); i++ {
+			var e MapElem
+			if e.Key, err = c.readAt(r, etype, 2*i); err != nil {
+				return
+			} else if e.Value, err = c.readAt(r, etype, 2*i+1); err != nil {
+				return
+			}
+			m = append(m, e)
+		}
+		term = m
 
 	case ettBitBinary:
 		// $MLLLLB…
 		var length uint32
 		var bits uint8
-		if length, err = ruint32(r); err != nil {
+		if length, err = r.uint32(); err != nil {
+			break
+		} else if bits, err = r.ReadByte(); err != nil {
 			break
-		} else if bits, err = ruint8(r); err != nil {
+		} else if (length == 0) != (bits == 0) || bits > 8 {
+			err = fmt.Errorf("%d bits in last byte of %d", bits, length)
+			break
+		} else if err = r.check("MaxBinary", c.Limits.MaxBinary, length); err != nil {
+			break
+		} else if b, err = r.bytes(length); err != nil {
 			break
 		}
-		b := make([]byte, length)
-		_, err = io.ReadFull(r, b)
-		b[len(b)-1] = b[len(b)-1] >> (8 - bits)
-		term = b
+		// unused bits are supposed to be zero, make sure they are
+		// without changing the input
+		if mask := ^byte(0) << (8 - bits); length != 0 && b[length-1]&^mask != 0 {
+			b = append([]byte(nil), b...)
+			b[length-1] &= mask
+		}
+		term = BitString{b, bits}
 
 	case ettExport:
-		// $qM…F…A
-		var m, f interface{}
-		var a uint8
-		if m, err = c.Read(r); err != nil {
+		// $qM…F…A…
+		var e Export
+		var arity uint32
+		if e.Module, err = c.readAtom(r, etype, 0); err != nil {
+			break
+		} else if e.Function, err = c.readAtom(r, etype, 1); err != nil {
 			break
-		} else if f, err = c.Read(r); err != nil {
+		} else if arity, err = c.readUint32(r, etype, 2); err != nil {
 			break
-		} else if a, err = ruint8(r); err != nil {
+		} else if arity > math.MaxUint8 {
+			err = fmt.Errorf("arity %d out of range", arity)
 			break
 		}
-
-		term = Export{m.(Atom), f.(Atom), a}
+		e.Arity = byte(arity)
+		term = e
 
 	case ettNewFun:
 		// $pSSSSAUUUUUUUUUUUUUUUUIIIIFFFFM…i…u…P…[V…]
 		var f Function
-		ruint32(r)
-		f.Arity, _ = ruint8(r)
-		io.ReadFull(r, f.Unique[:])
-		f.Index, _ = ruint32(r)
-		f.Free, _ = ruint32(r)
-		m, _ := c.Read(r)
-		oldi, _ := c.Read(r)
-		oldu, _ := c.Read(r)
-		pid, _ := c.Read(r)
-
-		f.FreeVars = make([]Term, f.Free)
-		for i := 0; i < cap(f.FreeVars); i++ {
-			if f.FreeVars[i], err = c.Read(r); err != nil {
-				break
-			}
+		if f.Size, err = r.uint32(); err != nil {
+			break
+		} else if f.Arity, err = r.ReadByte(); err != nil {
+			break
+		} else if _, err = io.ReadFull(r, f.Unique[:]); err != nil {
+			break
+		} else if f.Index, err = r.uint32(); err != nil {
+			break
+		} else if f.Free, err = r.uint32(); err != nil {
+			break
+		} else if f.Module, err = c.readAtom(r, etype, 0); err != nil {
+			break
+		} else if f.OldIndex, err = c.readUint32(r, etype, 1); err != nil {
+			break
+		} else if f.OldUnique, err = c.readUint32(r, etype, 2); err != nil {
+			break
+		} else if f.Pid, err = c.readPid(r, etype, 3); err != nil {
+			break
+		} else if f.FreeVars, err = c.readElements(r, etype, 4, f.Free); err != nil {
+			break
 		}
-
-		f.Module = m.(Atom)
-		f.OldIndex = uint32(oldi.(int))
-		f.OldUnique = uint32(oldu.(int))
-		f.Pid = pid.(Pid)
 		term = f
 
 	case ettFun:
 		// $uFFFFP…M…i…u…[V…]
 		var f Function
-		f.Free, _ = ruint32(r)
-		pid, _ := c.Read(r)
-		m, _ := c.Read(r)
-		oldi, _ := c.Read(r)
-		oldu, _ := c.Read(r)
-
-		f.FreeVars = make([]Term, f.Free)
-		for i := 0; i < cap(f.FreeVars); i++ {
-			if f.FreeVars[i], err = c.Read(r); err != nil {
-				break
-			}
+		if f.Free, err = r.uint32(); err != nil {
+			break
+		} else if f.Pid, err = c.readPid(r, etype, 0); err != nil {
+			break
+		} else if f.Module, err = c.readAtom(r, etype, 1); err != nil {
+			break
+		} else if f.OldIndex, err = c.readUint32(r, etype, 2); err != nil {
+			break
+		} else if f.OldUnique, err = c.readUint32(r, etype, 3); err != nil {
+			break
+		} else if f.FreeVars, err = c.readElements(r, etype, 4, f.Free); err != nil {
+			break
 		}
-
-		f.Module = m.(Atom)
-		f.OldIndex = uint32(oldi.(int))
-		f.OldUnique = uint32(oldu.(int))
-		f.Pid = pid.(Pid)
 		term = f
 
-	case ettPort:
-		// $fA…IIIIC
+	case ettPort, ettNewPort, ettV4Port:
+		// $f…IIIIC | $Y…IIIICCCC | $x…IIIIIIIICCCC
 		var p Port
-		a, _ := c.Read(r)
-		p.Node = a.(Atom)
-		p.Id, _ = ruint32(r)
-		p.Creation, err = ruint8(r)
+		if p.Node, err = c.readAtom(r, etype, 0); err != nil {
+			return
+		}
+		if etype == ettV4Port {
+			p.Id, err = r.uint64()
+		} else {
+			var id uint32
+			id, err = r.uint32()
+			p.Id = uint64(id)
+		}
+		if err != nil {
+			return
+		} else if p.Creation, err = r.creation(etype == ettPort); err != nil {
+			return
+		}
 		term = p
 
 	case ettCacheRef:
-		b = make([]byte, 1)
-		if _, err = io.ReadFull(r, b); err != nil {
+		var i uint8
+		if i, err = r.ReadByte(); err != nil {
+			break
+		} else if int(i) >= len(c.currentCache) || c.currentCache[i] == nil {
+			err = ErrCacheRef
 			break
 		}
-		term = Atom(*c.currentCache[b[0]])
+		term = newAtom(Atom(*c.currentCache[i]))
 
 	default:
 		err = &ErrUnknownTerm{etype}
@@ -411,17 +663,139 @@ func (e *ErrUnknownTerm) Error() string {
 	return fmt.Sprintf("read: unknown term type %d", e.termType)
 }
 
-func newAtom(b []byte) interface{} {
-	if bytes.Compare(b, bTrue) == 0 {
+// ErrSyntax is returned by Read for malformed input. It records where
+// in the input and where in the term being decoded the error happened.
+type ErrSyntax struct {
+	// Offset is the number of bytes read when the error was detected.
+	// Within a compressed term, it counts uncompressed bytes.
+	Offset int64
+	// Tag names the type of the term being decoded, as in "LIST_EXT".
+	// It is empty if the error happened reading the type itself.
+	Tag string
+	// Path leads from the outermost term to the one being decoded.
+	Path []PathElem
+	// Err is the underlying error.
+	Err error
+}
+
+// PathElem is a step into a term: the nested term at position Index
+// within a term of type Tag. Positions count the nested terms in the
+// order they are encoded, so for a map they alternate between keys
+// and values, and for a pid the node is at 0.
+type PathElem struct {
+	Tag   string
+	Index int
+}
+
+func (e *ErrSyntax) Error() string {
+	tag := e.Tag
+	if tag == "" {
+		tag = "term"
+	}
+	var path string
+	for i, p := range e.Path {
+		if i == 0 {
+			path = " in "
+		} else {
+			path += "/"
+		}
+		path += fmt.Sprintf("%s[%d]", p.Tag, p.Index)
+	}
+	return fmt.Sprintf("read: malformed %s at offset %d%s: %s",
+		tag, e.Offset, path, strings.TrimPrefix(e.Err.Error(), "read: "))
+}
+
+func (e *ErrSyntax) Unwrap() error {
+	return e.Err
+}
+
+// string returns l as Charlists says if it is a list of characters.
+func (c *Context) string(l List) Term {
+	if c.Charlists == CharlistsRaw || len(l) == 0 {
+		return l
+	}
+
+	s := make(Charlist, len(l))
+	for i, e := range l {
+		x, ok := e.(int)
+		if !ok || !c.isChar(x) {
+			return l
+		}
+		s[i] = rune(x)
+	}
+
+	if c.Charlists == CharlistsAsString {
+		return string(s)
+	}
+	return s
+}
+
+// isChar reports whether x is a character code in a string.
+func (c *Context) isChar(x int) bool {
+	if x >= 0 && x <= math.MaxUint8 {
 		return true
-	} else if bytes.Compare(b, bFalse) == 0 {
+	}
+	return c.UnicodeCharlists && x <= utf8.MaxRune && utf8.ValidRune(rune(x))
+}
+
+// fromLatin1 converts Latin-1 text to UTF-8, returning b itself if it
+// is ASCII.
+func fromLatin1(b []byte) []byte {
+	for i := range b {
+		if b[i] >= utf8.RuneSelf {
+			u := make([]byte, 0, len(b)+len(b)-i)
+			for _, c := range b {
+				u = utf8.AppendRune(u, rune(c))
+			}
+			return u
+		}
+	}
+	return b
+}
+
+// newAtom returns a, or a bool for true and false.
+func newAtom(a Atom) interface{} {
+	switch a {
+	case "true":
+		return true
+	case "false":
 		return false
 	}
+	return a
+}
+
+// atomName returns the atom named b, interned if c.Atoms is set. If not,
+// it is a copy of b unless r, if any, lets it share memory with b.
+func (c *Context) atomName(r *reader, b []byte) Atom {
+	if c.Atoms != nil {
+		return c.Atoms.intern(b)
+	} else if r != nil {
+		return Atom(r.text(b))
+	}
 	return Atom(b)
 }
 
-func readBigInt(r io.Reader, b []byte, sign byte) (interface{}, error) {
-	if _, err := io.ReadFull(r, b); err != nil {
+// readElements reads n terms, the elements of a tuple, a list or the
+// free variables of a fun, nested in a term of type tag from index first.
+func (c *Context) readElements(r *reader, tag byte, first int, n uint32) (elems []Term, err error) {
+	var size int
+	if size, err = r.elements(n); err != nil {
+		return
+	}
+	elems = make([]Term, 0, size)
+	for i := 0; i < int(n); i++ {
+		var t Term
+		if t, err = c.readAt(r, tag, first+i); err != nil {
+			return
+		}
+		elems = append(elems, t)
+	}
+	return
+}
+
+func readBigInt(r io.Reader, n uint32, sign byte) (interface{}, error) {
+	b, err := rbytes(r, n)
+	if err != nil {
 		return nil, err
 	}
 
@@ -446,36 +820,3 @@ func readBigInt(r io.Reader, b []byte, sign byte) (interface{}, error) {
 
 	return v, nil
 }
-
-func ruint8(r io.Reader) (uint8, error) {
-	b := []byte{0}
-	_, err := io.ReadFull(r, b)
-	return b[0], err
-}
-
-func ruint16(r io.Reader) (uint16, error) {
-	b := []byte{0, 0}
-	_, err := io.ReadFull(r, b)
-	return be.Uint16(b), err
-}
-
-func ruint32(r io.Reader) (uint32, error) {
-	b := []byte{0, 0, 0, 0}
-	_, err := io.ReadFull(r, b)
-	return be.Uint32(b), err
-}
-
-func buint8(r io.Reader) ([]byte, error) {
-	size, err := ruint8(r)
-	return make([]byte, size), err
-}
-
-func buint16(r io.Reader) ([]byte, error) {
-	size, err := ruint16(r)
-	return make([]byte, size), err
-}
-
-func buint32(r io.Reader) ([]byte, error) {
-	size, err := ruint32(r)
-	return make([]byte, size), err
-}
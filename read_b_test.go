@@ -12,13 +12,10 @@ import (
 )
 
 func BenchmarkReadAtom(b *testing.B) {
-	b.StopTimer()
-	c := new(Context)
-
 	rand.Seed(time.Now().UnixNano())
 	max := 64
 	length := 64
-	atoms := make([]*bytes.Buffer, max)
+	atoms := make([][]byte, max)
 
 	for i := 0; i < max; i++ {
 		w := new(bytes.Buffer)
@@ -26,19 +23,19 @@ func BenchmarkReadAtom(b *testing.B) {
 		b := bytes.Map(randRune, s)
 		w.Write([]byte{ettSmallAtom, byte(length)})
 		w.Write(b)
-		atoms[i] = w
+		atoms[i] = w.Bytes()
 	}
 
-	b.StartTimer()
-
-	for i := 0; i < b.N; i++ {
-		in := atoms[i%max]
-		_, err := c.Read(in)
+	benchmarkRead(b, atoms)
 
-		if err != io.EOF && err != nil {
-			b.Fatal(err)
+	b.Run("ReadInterned", func(b *testing.B) {
+		c := &Context{Atoms: NewAtomTable(0)}
+		for i := 0; i < b.N; i++ {
+			if _, err := c.Read(bytes.NewReader(atoms[i%max])); err != nil {
+				b.Fatal(err)
+			}
 		}
-	}
+	})
 }
 
 func BenchmarkReadBigInt(b *testing.B) {
@@ -79,13 +76,10 @@ func BenchmarkReadBigInt(b *testing.B) {
 }
 
 func BenchmarkReadBinary(b *testing.B) {
-	b.StopTimer()
-	c := new(Context)
-
 	rand.Seed(time.Now().UnixNano())
 	max := 64
 	length := 64
-	binaries := make([]*bytes.Buffer, max)
+	binaries := make([][]byte, max)
 
 	for i := 0; i < max; i++ {
 		w := new(bytes.Buffer)
@@ -94,19 +88,40 @@ func BenchmarkReadBinary(b *testing.B) {
 		w.Write([]byte{ettBinary})
 		binary.Write(w, binary.BigEndian, uint32(len(b)))
 		w.Write(b)
-		binaries[i] = w
+		binaries[i] = w.Bytes()
 	}
 
-	b.StartTimer()
-
-	for i := 0; i < b.N; i++ {
-		in := binaries[i%max]
-		_, err := c.Read(in)
+	benchmarkRead(b, binaries)
+}
 
-		if err != io.EOF && err != nil {
-			b.Fatal(err)
+// benchmarkRead compares reading terms with Read and with ReadBytes.
+func benchmarkRead(b *testing.B, terms [][]byte) {
+	b.Run("Read", func(b *testing.B) {
+		c := new(Context)
+		for i := 0; i < b.N; i++ {
+			if _, err := c.Read(bytes.NewReader(terms[i%len(terms)])); err != nil {
+				b.Fatal(err)
+			}
 		}
-	}
+	})
+
+	b.Run("ReadBytes", func(b *testing.B) {
+		c := new(Context)
+		for i := 0; i < b.N; i++ {
+			if _, _, err := c.ReadBytes(terms[i%len(terms)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("ReadBytesUnsafe", func(b *testing.B) {
+		c := &Context{UnsafeStrings: true}
+		for i := 0; i < b.N; i++ {
+			if _, _, err := c.ReadBytes(terms[i%len(terms)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
 }
 
 func BenchmarkReadFloat(b *testing.B) {
@@ -2,7 +2,11 @@ package etf
 
 import (
 	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
 	"math/big"
+	"reflect"
 	"testing"
 )
 
@@ -19,6 +23,18 @@ func TestReadAtom(t *testing.T) {
 		t.Errorf("expected %v, got %v", exp, v)
 	}
 
+	// 'é' in Latin-1 and in UTF-8
+	for _, b := range [][]byte{{100, 0, 1, 233}, {115, 1, 233}, {118, 0, 2, 195, 169}, {119, 2, 195, 169}} {
+		if v, err := c.Read(bytes.NewBuffer(b)); err != nil {
+			t.Error(b, err)
+		} else if exp := Atom("é"); exp != v {
+			t.Errorf("%v: expected %v, got %v", b, exp, v)
+		}
+	}
+	if _, err := c.Read(bytes.NewBuffer([]byte{119, 1, 233})); err == nil {
+		t.Error("err == nil")
+	}
+
 	// ''
 	in = bytes.NewBuffer([]byte{100, 0, 0})
 	if v, err := c.Read(in); err != nil {
@@ -83,9 +99,20 @@ func TestReadBitBinary(t *testing.T) {
 		t.Error(err)
 	} else if l := in.Len(); l != 0 {
 		t.Errorf("buffer len %d", l)
-	} else if exp := []byte{1, 2, 3, 4, 5}; bytes.Compare(exp, v.([]byte)) != 0 {
+	} else if exp := (BitString{[]byte{1, 2, 3, 4, 160}, 3}); !reflect.DeepEqual(exp, v) {
 		t.Errorf("expected %v, got %v", exp, v)
 	}
+
+	// errors (bits out of range)
+	for _, b := range [][]byte{
+		{77, 0, 0, 0, 1, 0, 1},
+		{77, 0, 0, 0, 1, 9, 1},
+		{77, 0, 0, 0, 0, 1},
+	} {
+		if _, err := c.Read(bytes.NewBuffer(b)); err == nil {
+			t.Errorf("%v: err == nil", b)
+		}
+	}
 }
 
 func TestReadBool(t *testing.T) {
@@ -122,6 +149,48 @@ func TestReadBool(t *testing.T) {
 	}
 }
 
+func TestReadCompressed(t *testing.T) {
+	c := new(Context)
+	compress := func(size int, term []byte) []byte {
+		w := bytes.NewBuffer([]byte{80, byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size)})
+		zw := zlib.NewWriter(w)
+		zw.Write(term)
+		zw.Close()
+		return w.Bytes()
+	}
+
+	// "aaa…" followed by 'ok'
+	str := append([]byte{107, 1, 0}, bytes.Repeat([]byte{'a'}, 256)...)
+	in := bytes.NewBuffer(compress(len(str), str))
+	in.Write([]byte{100, 0, 2, 111, 107})
+	if v, err := c.Read(in); err != nil {
+		t.Error(err)
+	} else if exp := string(str[3:]); v != exp {
+		t.Errorf("expected %v, got %v", exp, v)
+	} else if v, err := c.Read(in); err != nil {
+		t.Error(err)
+	} else if exp := Atom("ok"); v != exp {
+		t.Errorf("expected %v, got %v", exp, v)
+	}
+
+	// error (declared size too big)
+	if _, err := c.Read(bytes.NewBuffer(compress(len(str)+1, str))); !errors.Is(err, ErrCompressedSize) {
+		t.Errorf("expected %v, got %v", ErrCompressedSize, err)
+	}
+
+	// error (declared size too small)
+	if _, err := c.Read(bytes.NewBuffer(compress(len(str)-1, str))); !errors.Is(err, ErrCompressedSize) {
+		t.Errorf("expected %v, got %v", ErrCompressedSize, err)
+	}
+
+	// error (corrupted data)
+	b := compress(len(str), str)
+	b[len(b)-1]++
+	if _, err := c.Read(bytes.NewBuffer(b)); err == nil {
+		t.Error("err == nil")
+	}
+}
+
 func TestReadFloat(t *testing.T) {
 	c := new(Context)
 
@@ -177,6 +246,73 @@ func TestReadFloat(t *testing.T) {
 	}
 }
 
+// testFun is fun(X) -> X + N end of module m, with N = 42, as
+// NEW_FUN_EXT.
+var testFun = func() []byte {
+	b := []byte{112, 0, 0, 0, 0, 1}
+	for i := 1; i <= 16; i++ {
+		b = append(b, byte(i))
+	}
+	b = append(b,
+		0, 0, 0, 5, 0, 0, 0, 1,
+		119, 1, 'm',
+		97, 5,
+		98, 7, 91, 205, 21,
+		88, 119, 3, 'n', '@', 'h', 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0,
+		97, 42,
+	)
+	be.PutUint32(b[1:5], uint32(len(b)-1))
+	return b
+}()
+
+func TestReadFun(t *testing.T) {
+	c := new(Context)
+
+	v, err := c.Read(bytes.NewReader(testFun))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := Function{
+		Size:      uint32(len(testFun) - 1),
+		Arity:     1,
+		Unique:    [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		Index:     5,
+		Free:      1,
+		Module:    "m",
+		OldIndex:  5,
+		OldUnique: 123456789,
+		Pid:       Pid{Node: "n@h", Id: 1},
+		FreeVars:  []Term{42},
+	}
+	if !reflect.DeepEqual(v, exp) {
+		t.Errorf("expected %#v, got %#v", exp, v)
+	}
+
+	// fun m:f/2
+	in := []byte{113, 119, 1, 'm', 119, 1, 'f', 97, 2}
+	if v, err = c.Read(bytes.NewReader(in)); err != nil {
+		t.Error(err)
+	} else if exp := (Export{"m", "f", 2}); v != exp {
+		t.Errorf("expected %v, got %v", exp, v)
+	}
+
+	// errors (truncated, arity out of range)
+	for i := 1; i < len(testFun); i++ {
+		if _, err = c.Read(bytes.NewReader(testFun[:i])); err == nil {
+			t.Errorf("%v: err == nil", testFun[:i])
+		}
+	}
+	for i := 1; i < len(in); i++ {
+		if _, err = c.Read(bytes.NewReader(in[:i])); err == nil {
+			t.Errorf("%v: err == nil", in[:i])
+		}
+	}
+	in = []byte{113, 119, 1, 'm', 119, 1, 'f', 98, 0, 0, 1, 0}
+	if _, err = c.Read(bytes.NewReader(in)); err == nil {
+		t.Error("err == nil")
+	}
+}
+
 func TestReadInt(t *testing.T) {
 	c := new(Context)
 
@@ -321,6 +457,69 @@ func TestReadInt(t *testing.T) {
 	}
 }
 
+func TestReadImproperList(t *testing.T) {
+	c := new(Context)
+
+	// [a, b | c]
+	in := bytes.NewBuffer([]byte{108, 0, 0, 0, 2, 100, 0, 1, 97, 100, 0, 1, 98, 100, 0, 1, 99})
+	exp := ImproperList{List{Atom("a"), Atom("b")}, Atom("c")}
+	if v, err := c.Read(in); err != nil {
+		t.Error(err)
+	} else if l := in.Len(); l != 0 {
+		t.Errorf("buffer len %d", l)
+	} else if !reflect.DeepEqual(v, exp) {
+		t.Errorf("expected %#v, got %#v", exp, v)
+	}
+
+	// [a, b] with a nil tail is proper
+	in = bytes.NewBuffer([]byte{108, 0, 0, 0, 2, 100, 0, 1, 97, 100, 0, 1, 98, 106})
+	if v, err := c.Read(in); err != nil {
+		t.Error(err)
+	} else if exp := (List{Atom("a"), Atom("b")}); !reflect.DeepEqual(v, exp) {
+		t.Errorf("expected %#v, got %#v", exp, v)
+	}
+}
+
+func TestReadMap(t *testing.T) {
+	c := new(Context)
+
+	// #{a => 1, {x} => <<"b">>}
+	in := bytes.NewBuffer([]byte{
+		116, 0, 0, 0, 2,
+		100, 0, 1, 97, 97, 1,
+		104, 1, 100, 0, 1, 120, 109, 0, 0, 0, 1, 98,
+	})
+	if v, err := c.Read(in); err != nil {
+		t.Error(err)
+	} else if l := in.Len(); l != 0 {
+		t.Errorf("buffer len %d", l)
+	} else if m, ok := v.(Map); !ok || len(m) != 2 {
+		t.Errorf("expected map of 2, got %#v", v)
+	} else if m[0].Key != Atom("a") || m[0].Value != 1 {
+		t.Errorf("bad first pair %#v", m[0])
+	} else if k, ok := m[1].Key.(Tuple); !ok || len(k) != 1 || k[0] != Atom("x") {
+		t.Errorf("bad second key %#v", m[1].Key)
+	} else if bytes.Compare(m[1].Value.([]byte), []byte("b")) != 0 {
+		t.Errorf("bad second value %#v", m[1].Value)
+	}
+
+	// #{}
+	in = bytes.NewBuffer([]byte{116, 0, 0, 0, 0})
+	if v, err := c.Read(in); err != nil {
+		t.Error(err)
+	} else if l := in.Len(); l != 0 {
+		t.Errorf("buffer len %d", l)
+	} else if m, ok := v.(Map); !ok || len(m) != 0 {
+		t.Errorf("expected empty map, got %#v", v)
+	}
+
+	// error (missing value)
+	in = bytes.NewBuffer([]byte{116, 0, 0, 0, 1, 97, 1})
+	if _, err := c.Read(in); err == nil {
+		t.Error("err == nil")
+	}
+}
+
 func TestReadPid(t *testing.T) {
 	c := new(Context)
 
@@ -357,6 +556,87 @@ func TestReadPid(t *testing.T) {
 	}
 }
 
+func TestReadNewPid(t *testing.T) {
+	c := new(Context)
+
+	// lol@localhost, creation 0x01020304
+	in := bytes.NewBuffer([]byte{
+		88, 119, 13, 108, 111, 108,
+		64, 108, 111, 99, 97, 108,
+		104, 111, 115, 116, 0, 1,
+		0, 38, 0, 0, 0, 5, 1, 2, 3, 4,
+	})
+	exp := Pid{Atom("lol@localhost"), 65574, 5, 0x01020304}
+	if v, err := c.Read(in); err != nil {
+		t.Error(err)
+	} else if l := in.Len(); l != 0 {
+		t.Errorf("buffer len %d", l)
+	} else if v != exp {
+		t.Errorf("expected %v, got %v", exp, v)
+	}
+
+	// error (short creation)
+	in = bytes.NewBuffer([]byte{88, 119, 1, 97, 0, 0, 0, 1, 0, 0, 0, 0, 0})
+	if _, err := c.Read(in); err == nil {
+		t.Error("err == nil")
+	}
+}
+
+func TestReadPort(t *testing.T) {
+	c := new(Context)
+	test := func(in []byte, exp Port) {
+		r := bytes.NewBuffer(in)
+		if v, err := c.Read(r); err != nil {
+			t.Error(in, err)
+		} else if l := r.Len(); l != 0 {
+			t.Errorf("%v: buffer len %d", in, l)
+		} else if v != exp {
+			t.Errorf("expected %v, got %v", exp, v)
+		}
+	}
+
+	// #Port<0.7>
+	test([]byte{102, 100, 0, 1, 97, 0, 0, 0, 7, 3}, Port{Atom("a"), 7, 3})
+	test([]byte{89, 100, 0, 1, 97, 0, 0, 0, 7, 0, 0, 1, 0}, Port{Atom("a"), 7, 256})
+	test(
+		[]byte{120, 100, 0, 1, 97, 0, 0, 0, 1, 0, 0, 0, 7, 0, 0, 1, 0},
+		Port{Atom("a"), 1<<32 | 7, 256},
+	)
+}
+
+func TestReadRef(t *testing.T) {
+	c := new(Context)
+	test := func(in []byte, exp Ref) {
+		r := bytes.NewBuffer(in)
+		if v, err := c.Read(r); err != nil {
+			t.Error(in, err)
+		} else if l := r.Len(); l != 0 {
+			t.Errorf("%v: buffer len %d", in, l)
+		} else if ref, ok := v.(Ref); !ok {
+			t.Errorf("expected %v, got %v", exp, v)
+		} else if ref.Node != exp.Node || ref.Creation != exp.Creation ||
+			len(ref.Id) != len(exp.Id) {
+			t.Errorf("expected %v, got %v", exp, v)
+		} else {
+			for i := range exp.Id {
+				if ref.Id[i] != exp.Id[i] {
+					t.Errorf("expected %v, got %v", exp, v)
+				}
+			}
+		}
+	}
+
+	test([]byte{101, 100, 0, 1, 97, 0, 0, 0, 9, 2}, Ref{Atom("a"), 2, []uint32{9}})
+	test(
+		[]byte{114, 0, 2, 100, 0, 1, 97, 3, 0, 0, 0, 1, 0, 0, 0, 2},
+		Ref{Atom("a"), 3, []uint32{1, 2}},
+	)
+	test(
+		[]byte{90, 0, 2, 100, 0, 1, 97, 0, 0, 0, 3, 0, 0, 0, 1, 0, 0, 0, 2},
+		Ref{Atom("a"), 3, []uint32{1, 2}},
+	)
+}
+
 func TestReadString(t *testing.T) {
 	c := new(Context)
 
@@ -406,6 +686,39 @@ func TestReadString(t *testing.T) {
 	}
 }
 
+func TestReadCharlists(t *testing.T) {
+	// "aé" as STRING_EXT and as LIST_EXT, "€" and a list that isn't a string
+	str := []byte{107, 0, 2, 97, 233}
+	list := []byte{108, 0, 0, 0, 2, 97, 97, 97, 233, 106}
+	euro := []byte{108, 0, 0, 0, 1, 98, 0, 0, 32, 172, 106}
+	notStr := []byte{108, 0, 0, 0, 2, 97, 97, 100, 0, 1, 97, 106}
+
+	cases := []struct {
+		c   Context
+		in  []byte
+		exp Term
+	}{
+		{Context{}, str, string([]byte{97, 233})},
+		{Context{}, list, List{97, 233}},
+		{Context{Charlists: CharlistsAsCharlist}, str, Charlist{97, 233}},
+		{Context{Charlists: CharlistsAsCharlist}, list, Charlist{97, 233}},
+		{Context{Charlists: CharlistsAsString}, str, "aé"},
+		{Context{Charlists: CharlistsAsString}, list, "aé"},
+		{Context{Charlists: CharlistsAsString}, euro, List{8364}},
+		{Context{Charlists: CharlistsAsString, UnicodeCharlists: true}, euro, "€"},
+		{Context{Charlists: CharlistsAsString}, notStr, List{97, Atom("a")}},
+		{Context{Charlists: CharlistsAsString}, []byte{106}, List{}},
+	}
+
+	for _, tc := range cases {
+		if v, err := tc.c.Read(bytes.NewReader(tc.in)); err != nil {
+			t.Error(tc.in, err)
+		} else if !reflect.DeepEqual(v, tc.exp) {
+			t.Errorf("%v: expected %#v, got %#v", tc.in, tc.exp, v)
+		}
+	}
+}
+
 func TestReadTerm(t *testing.T) {
 	c := new(Context)
 
@@ -430,3 +743,224 @@ func TestReadTerm(t *testing.T) {
 		t.Errorf("buffer len %d", l)
 	}
 }
+
+func TestUnmarshal(t *testing.T) {
+	// {ok, "abc"}
+	in := []byte{131, 104, 2, 100, 0, 2, 111, 107, 107, 0, 3, 97, 98, 99}
+	var term Term
+	if err := Unmarshal(in, &term); err != nil {
+		t.Error(err)
+	} else if v, ok := term.(Tuple); !ok || len(v) != 2 || v[0] != Atom("ok") || v[1] != "abc" {
+		t.Errorf("unexpected %#v", term)
+	}
+
+	var tuple Tuple
+	if err := Unmarshal(in, &tuple); err != nil {
+		t.Error(err)
+	} else if len(tuple) != 2 {
+		t.Errorf("unexpected %#v", tuple)
+	}
+
+	var atom Atom
+	if err := Unmarshal([]byte{131, 100, 0, 2, 111, 107}, &atom); err != nil {
+		t.Error(err)
+	} else if atom != Atom("ok") {
+		t.Errorf("expected ok, got %v", atom)
+	}
+
+	// errors
+	for _, in := range [][]byte{
+		{},
+		{131},
+		{130, 97, 1},
+		{97, 1},
+		{131, 97, 1, 0},
+	} {
+		if err := Unmarshal(in, &term); err == nil {
+			t.Errorf("%v: err == nil", in)
+		}
+	}
+	if err := Unmarshal([]byte{131, 97, 1}, &atom); err == nil {
+		t.Error("err == nil")
+	}
+	if err := Unmarshal([]byte{131, 97, 1}, term); err == nil {
+		t.Error("err == nil")
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	c := new(Context)
+
+	// {1, [a, <pid with 1 as node>]}
+	in := []byte{104, 2, 97, 1, 108, 0, 0, 0, 2, 100, 0, 1, 97, 103, 97, 1,
+		0, 0, 0, 1, 0, 0, 0, 0, 0}
+	_, err := c.Read(bytes.NewReader(in))
+	var se *ErrSyntax
+	if !errors.As(err, &se) {
+		t.Fatalf("expected ErrSyntax, got %v", err)
+	} else if se.Offset != 16 || se.Tag != "PID_EXT" {
+		t.Errorf("unexpected %#v", se)
+	}
+	path := []PathElem{{"SMALL_TUPLE_EXT", 1}, {"LIST_EXT", 1}}
+	if !reflect.DeepEqual(se.Path, path) {
+		t.Errorf("expected path %v, got %v", path, se.Path)
+	}
+
+	// nothing at all
+	if _, err = c.Read(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("expected %v, got %v", io.EOF, err)
+	}
+
+	for _, in := range [][]byte{
+		// cache reference without a distribution header
+		{82, 0},
+		// reference with a binary as node
+		{101, 109, 0, 0, 0, 0, 0, 0, 0, 1, 0},
+		// export with a binary as function
+		{113, 100, 0, 1, 109, 109, 0, 0, 0, 0, 0},
+		// FUN_EXT with an atom as old index
+		{117, 0, 0, 0, 0, 103, 100, 0, 1, 110, 0, 0, 0, 1, 0, 0, 0, 0, 0,
+			100, 0, 1, 109, 100, 0, 1, 105, 97, 0},
+		// bitstring with 9 bits in its last byte
+		{77, 0, 0, 0, 1, 9, 255},
+	} {
+		_, err = c.Read(bytes.NewReader(in))
+		if !errors.As(err, &se) {
+			t.Errorf("%v: expected ErrSyntax, got %v", in, err)
+		}
+	}
+
+	// every truncation of a valid term
+	buf := new(bytes.Buffer)
+	term := Tuple{1, List{Atom("a"), "str", []byte{1}}, Map{{1.5, big.NewInt(1 << 62)}},
+		Pid{Node: "n@h", Id: 1}, Ref{Node: "n@h", Id: []uint32{1, 2, 3}}}
+	if err = c.Write(buf, term); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	for i := 1; i < len(b); i++ {
+		_, err = c.Read(bytes.NewReader(b[:i]))
+		if !errors.As(err, &se) {
+			t.Errorf("%v: expected ErrSyntax, got %v", b[:i], err)
+		} else if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%v: expected %v, got %v", b[:i], io.ErrUnexpectedEOF, err)
+		}
+	}
+}
+
+func TestReadBytes(t *testing.T) {
+	c := new(Context)
+
+	terms := []Term{
+		Tuple{1, -1, 300, int64(1) << 40, big.NewInt(0).Lsh(big.NewInt(1), 70), 1.5},
+		List{Atom("a"), "str", []byte{1, 2}, BitString{[]byte{0xe0}, 3}, Atom("é")},
+		Map{{Atom("k"), List{}}, {true, false}},
+		Pid{Node: "n@h", Id: 1, Serial: 2, Creation: 3},
+		Port{Node: "n@h", Id: 1 << 40, Creation: 3},
+		Ref{Node: "n@h", Creation: 1, Id: []uint32{1, 2, 3}},
+		ImproperList{List{1}, 2},
+		Export{"m", "f", 2},
+		testFun,
+	}
+	for _, term := range terms {
+		buf := new(bytes.Buffer)
+		if err := c.Write(buf, term); err != nil {
+			t.Fatal(err)
+		}
+		exp, err := c.Read(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// with something after the term
+		b := append(buf.Bytes(), 106)
+		v, n, err := c.ReadBytes(b)
+		if err != nil {
+			t.Errorf("%v: %v", term, err)
+		} else if n != buf.Len() {
+			t.Errorf("%v: expected %d bytes, got %d", term, buf.Len(), n)
+		} else if !reflect.DeepEqual(v, exp) {
+			t.Errorf("expected %#v, got %#v", exp, v)
+		}
+	}
+
+	// binaries are slices of the input, other terms are not unless
+	// they are in a compressed term
+	b := []byte{104, 3, 109, 0, 0, 0, 2, 1, 2, 100, 0, 1, 97, 107, 0, 1, 98}
+	v, _, err := c.ReadBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[7], b[12], b[16] = 9, 'x', 'y'
+	if exp := (Tuple{[]byte{9, 2}, Atom("a"), "b"}); !reflect.DeepEqual(v, exp) {
+		t.Errorf("expected %#v, got %#v", exp, v)
+	}
+
+	c.UnsafeStrings = true
+	v, _, err = c.ReadBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[12], b[16] = 'z', 'w'
+	if exp := (Tuple{[]byte{9, 2}, Atom("z"), "w"}); !reflect.DeepEqual(v, exp) {
+		t.Errorf("expected %#v, got %#v", exp, v)
+	}
+
+	// unused bits are cleared in a copy
+	b = []byte{77, 0, 0, 0, 1, 3, 0xff}
+	if v, _, err := c.ReadBytes(b); err != nil {
+		t.Error(err)
+	} else if exp := (BitString{[]byte{0xe0}, 3}); !reflect.DeepEqual(v, exp) {
+		t.Errorf("expected %#v, got %#v", exp, v)
+	} else if b[6] != 0xff {
+		t.Error("input changed")
+	}
+
+	// compressed [1, 2, ..., 100]
+	c.Compression = zlib.BestCompression
+	buf := new(bytes.Buffer)
+	list := make(List, 100)
+	for i := range list {
+		list[i] = 1000 + i
+	}
+	if err = c.Write(buf, list); err != nil {
+		t.Fatal(err)
+	} else if buf.Bytes()[0] != ettCompressed {
+		t.Fatal("not compressed")
+	}
+	if v, n, err := c.ReadBytes(buf.Bytes()); err != nil {
+		t.Error(err)
+	} else if n != buf.Len() {
+		t.Errorf("expected %d bytes, got %d", buf.Len(), n)
+	} else if !reflect.DeepEqual(v, list) {
+		t.Errorf("expected %v, got %v", list, v)
+	}
+
+	// errors
+	if _, _, err = c.ReadBytes(nil); err != io.EOF {
+		t.Errorf("expected %v, got %v", io.EOF, err)
+	}
+	var se *ErrSyntax
+	if _, _, err = c.ReadBytes([]byte{104, 2, 97, 1, 109, 0, 0, 0, 5, 1}); !errors.As(err, &se) {
+		t.Errorf("expected ErrSyntax, got %v", err)
+	} else if !errors.Is(err, io.ErrUnexpectedEOF) || se.Offset != 10 {
+		t.Errorf("unexpected %#v", se)
+	}
+	c.Limits.MaxBytes = 8
+	var le *ErrLimit
+	if _, _, err = c.ReadBytes([]byte{109, 0, 0, 0, 5, 1, 2, 3, 4, 5}); !errors.As(err, &le) {
+		t.Errorf("expected ErrLimit, got %v", err)
+	}
+}
+
+func TestReadBytesAllocs(t *testing.T) {
+	c := &Context{UnsafeStrings: true}
+	b := []byte{104, 3, 109, 0, 0, 0, 2, 1, 2, 100, 0, 1, 97, 107, 0, 1, 98}
+	n := testing.AllocsPerRun(100, func() {
+		c.ReadBytes(b)
+	})
+	// the reader, its path, the tuple and the four terms in interfaces
+	if n > 7 {
+		t.Errorf("%v allocations", n)
+	}
+}
@@ -0,0 +1,433 @@
+package etf
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"unicode/utf8"
+)
+
+// EncodedSize returns the number of bytes that AppendTerm appends for
+// term, unless it compresses them, which only makes them fewer. It
+// fails where AppendTerm would, and calls MarshalETF and MarshalTerm
+// just as AppendTerm does.
+//
+// Sizing a term first allows a buffer to be allocated once for it:
+//
+//	n, err := c.EncodedSize(term)
+//	...
+//	b, err := c.AppendTerm(make([]byte, 0, n), term)
+func (c *Context) EncodedSize(term Term) (int, error) {
+	return c.termSize(term)
+}
+
+// termSize mirrors appendTerm.
+func (c *Context) termSize(term interface{}) (int, error) {
+	switch v := term.(type) {
+	case Marshaler:
+		b, err := v.MarshalETF()
+		return len(b), err
+	case TermMarshaler:
+		t, err := v.MarshalTerm()
+		if err != nil {
+			return 0, err
+		}
+		return c.termSize(t)
+	case bool:
+		return c.boolSize(v)
+	case int8, int16, int32, int64, int:
+		return intSize(reflect.ValueOf(term).Int()), nil
+	case uint8, uint16, uint32, uint64, uintptr, uint:
+		return uintSize(reflect.ValueOf(term).Uint()), nil
+	case *big.Int:
+		return bigIntEncodedSize(v)
+	case string:
+		return c.stringSize(v, c.Strings)
+	case []byte:
+		return binarySize(len(v))
+	case Charlist:
+		return c.charlistSize(v)
+	case BitString:
+		return bitStringSize(v)
+	case float64, float32:
+		// $FFFFFFFFF
+		return 9, nil
+	case Atom:
+		return c.atomSize(v)
+	case Pid:
+		return c.pidSize(v)
+	case Port:
+		return c.portSize(v)
+	case Tuple:
+		return c.termsSize(tupleHeadSize(len(v)), v)
+	case Ref:
+		return c.refSize(v)
+	case Map:
+		return c.mapSize(v)
+	case ImproperList:
+		return c.improperListSize(v)
+	case Function:
+		return c.functionSize(v)
+	case Export:
+		return c.exportSize(v)
+	}
+
+	rv := reflect.ValueOf(term)
+	switch rv.Kind() {
+	case reflect.Struct:
+		return c.structSize(rv)
+	case reflect.Array, reflect.Slice:
+		return c.listSize(rv)
+	case reflect.Map:
+		return c.mapSize(c.goMap(rv))
+	case reflect.Ptr:
+		if !rv.IsNil() {
+			return c.valueSize(rv.Elem())
+		}
+	}
+	return 0, &ErrUnknownType{reflect.TypeOf(term)}
+}
+
+// valueSize mirrors appendValue.
+func (c *Context) valueSize(v reflect.Value) (int, error) {
+	if t := v.Type(); t.PkgPath() == "" && t.Name() != "" {
+		switch v.Kind() {
+		case reflect.Bool:
+			return c.boolSize(v.Bool())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return intSize(v.Int()), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return uintSize(v.Uint()), nil
+		case reflect.Float32, reflect.Float64:
+			return 9, nil
+		case reflect.String:
+			return c.stringSize(v.String(), c.Strings)
+		}
+	} else if t == atomType {
+		return c.atomSize(Atom(v.String()))
+	}
+	return c.termSize(v.Interface())
+}
+
+// termsSize returns n plus the sizes of terms.
+func (c *Context) termsSize(n int, terms []Term) (int, error) {
+	for _, t := range terms {
+		m, err := c.termSize(t)
+		if err != nil {
+			return 0, err
+		}
+		n += m
+	}
+	return n, nil
+}
+
+func (c *Context) atomSize(atom Atom) (int, error) {
+	if _, ok := c.atomRef(atom); ok {
+		return 2, nil
+	}
+
+	tag, err := c.atomTag(atom)
+	if err != nil {
+		return 0, err
+	}
+	switch tag {
+	case ettSmallAtom:
+		return 2 + utf8.RuneCountInString(string(atom)), nil
+	case ettSmallAtomUTF8:
+		return 2 + len(atom), nil
+	}
+	return 3 + len(atom), nil
+}
+
+func (c *Context) boolSize(v bool) (int, error) {
+	if v {
+		return c.atomSize(Atom("true"))
+	}
+	return c.atomSize(Atom("false"))
+}
+
+func bigIntEncodedSize(x *big.Int) (int, error) {
+	switch size := bigIntSize(x); {
+	case size <= math.MaxUint8:
+		return 3 + size, nil
+	case int64(size) <= math.MaxUint32:
+		return 6 + size, nil
+	default:
+		return 0, fmt.Errorf("bad big int size (%d)", size)
+	}
+}
+
+func intSize(x int64) int {
+	switch {
+	case x >= 0 && x <= math.MaxUint8:
+		return 2
+	case x >= math.MinInt32 && x <= math.MaxInt32:
+		return 5
+	}
+
+	u := uint64(x)
+	if x < 0 {
+		u = -u
+	}
+	return 3 + smallBigSize(u)
+}
+
+func uintSize(x uint64) int {
+	switch {
+	case x <= math.MaxUint8:
+		return 2
+	case x <= math.MaxInt32:
+		return 5
+	}
+	return 3 + smallBigSize(x)
+}
+
+func binarySize(size int) (int, error) {
+	if int64(size) > math.MaxUint32 {
+		return 0, fmt.Errorf("bad binary size (%d)", size)
+	}
+	return 5 + size, nil
+}
+
+func bitStringSize(s BitString) (int, error) {
+	if len(s.Bytes) == 0 {
+		return binarySize(0)
+	} else if s.Bits == 0 || s.Bits > 8 {
+		return 0, fmt.Errorf("bad bitstring bits (%d)", s.Bits)
+	}
+
+	size := len(s.Bytes)
+	if int64(size) > math.MaxUint32 {
+		return 0, fmt.Errorf("bad bitstring size (%d)", size)
+	}
+	return 6 + size, nil
+}
+
+func (c *Context) pidSize(p Pid) (int, error) {
+	n, err := c.atomSize(p.Node)
+	if err != nil {
+		return 0, err
+	}
+	m, err := creationSize(p.Creation, c.legacyNodeTerms())
+	if err != nil {
+		return 0, err
+	}
+	return 1 + n + 8 + m, nil
+}
+
+func (c *Context) portSize(p Port) (int, error) {
+	n, err := c.atomSize(p.Node)
+	if err != nil {
+		return 0, err
+	}
+
+	var m int
+	switch {
+	case p.Id > math.MaxUint32:
+		if c.TargetOTP != 0 && c.TargetOTP < 24 {
+			return 0, fmt.Errorf("port id %d needs OTP 24", p.Id)
+		}
+		return 1 + n + 8 + 4, nil
+	case c.legacyNodeTerms():
+		if m, err = creationSize(p.Creation, true); err != nil {
+			return 0, err
+		}
+	default:
+		m = 4
+	}
+	return 1 + n + 4 + m, nil
+}
+
+// creationSize mirrors appendCreation.
+func creationSize(creation uint32, legacy bool) (int, error) {
+	if !legacy {
+		return 4, nil
+	} else if creation > math.MaxUint8 {
+		return 0, fmt.Errorf("creation %d needs OTP 19", creation)
+	}
+	return 1, nil
+}
+
+// stringSize mirrors appendStringAs.
+func (c *Context) stringSize(s string, f StringFormat) (int, error) {
+	switch f {
+	case StringDefault:
+		size := len(s)
+		if size > math.MaxUint16 {
+			return 0, fmt.Errorf("string is too big (%d bytes)", size)
+		}
+		return 3 + size, nil
+	case StringBinary:
+		return binarySize(len(s))
+	case StringAtom:
+		return c.atomSize(Atom(s))
+	}
+	return c.termSize(stringTerm(s, f))
+}
+
+func (c *Context) charlistSize(l Charlist) (int, error) {
+	if len(l) == 0 {
+		return 1, nil
+	} else if !l.isString() {
+		return c.listSize(reflect.ValueOf(l))
+	}
+	return 3 + len(l), nil
+}
+
+func (c *Context) listSize(l reflect.Value) (int, error) {
+	count := l.Len()
+	if count == 0 {
+		return 1, nil
+	}
+
+	n := 5 + 1
+	for i := 0; i < count; i++ {
+		m, err := c.valueSize(l.Index(i))
+		if err != nil {
+			return 0, err
+		}
+		n += m
+	}
+	return n, nil
+}
+
+func (c *Context) improperListSize(l ImproperList) (int, error) {
+	if len(l.Elems) == 0 {
+		return c.termSize(l.Tail)
+	}
+
+	n, err := c.termsSize(5, l.Elems)
+	if err != nil {
+		return 0, err
+	}
+	m, err := c.termSize(l.Tail)
+	return n + m, err
+}
+
+func (c *Context) mapSize(m Map) (int, error) {
+	n := 5
+	for _, e := range m {
+		k, err := c.termSize(e.Key)
+		if err != nil {
+			return 0, err
+		}
+		v, err := c.termSize(e.Value)
+		if err != nil {
+			return 0, err
+		}
+		n += k + v
+	}
+	return n, nil
+}
+
+func (c *Context) functionSize(f Function) (int, error) {
+	if f.isOld() {
+		return c.oldFunctionSize(f)
+	}
+
+	n := 1 + 4 + 1 + len(f.Unique) + 4 + 4
+	m, err := c.atomSize(f.Module)
+	if err != nil {
+		return 0, err
+	}
+	n += m + uintSize(uint64(f.OldIndex)) + uintSize(uint64(f.OldUnique))
+	if m, err = c.pidSize(f.Pid); err != nil {
+		return 0, err
+	}
+	if n, err = c.termsSize(n+m, f.FreeVars); err != nil {
+		return 0, err
+	}
+
+	if size := n - 1; int64(size) > math.MaxUint32 {
+		return 0, fmt.Errorf("bad fun size (%d)", size)
+	}
+	return n, nil
+}
+
+func (c *Context) oldFunctionSize(f Function) (int, error) {
+	n := 1 + 4
+	m, err := c.pidSize(f.Pid)
+	if err != nil {
+		return 0, err
+	}
+	n += m
+	if m, err = c.atomSize(f.Module); err != nil {
+		return 0, err
+	}
+	n += m + uintSize(uint64(f.OldIndex)) + uintSize(uint64(f.OldUnique))
+	return c.termsSize(n, f.FreeVars)
+}
+
+func (c *Context) exportSize(e Export) (int, error) {
+	m, err := c.atomSize(e.Module)
+	if err != nil {
+		return 0, err
+	}
+	f, err := c.atomSize(e.Function)
+	if err != nil {
+		return 0, err
+	}
+	return 1 + m + f + 2, nil
+}
+
+func (c *Context) structSize(rv reflect.Value) (int, error) {
+	si := getStructInfo(rv.Type())
+
+	var n int
+	if si.asMap {
+		n = 5
+		for i := range si.fields {
+			k, err := c.atomSize(si.fields[i].key)
+			if err != nil {
+				return 0, err
+			}
+			n += k
+		}
+	} else if si.hasTag {
+		t, err := c.atomSize(si.tag)
+		if err != nil {
+			return 0, err
+		}
+		n = tupleHeadSize(len(si.fields)+1) + t
+	} else {
+		n = tupleHeadSize(len(si.fields))
+	}
+
+	for i := range si.fields {
+		m, err := c.fieldSize(&si.fields[i], rv)
+		if err != nil {
+			return 0, err
+		}
+		n += m
+	}
+	return n, nil
+}
+
+// fieldSize mirrors appendField.
+func (c *Context) fieldSize(f *fieldInfo, rv reflect.Value) (int, error) {
+	v := rv.Field(f.index)
+	if v.Kind() == reflect.String && f.format != StringDefault {
+		return c.stringSize(v.String(), f.format)
+	}
+	return c.valueSize(v)
+}
+
+func (c *Context) refSize(ref Ref) (int, error) {
+	n, err := c.atomSize(ref.Node)
+	if err != nil {
+		return 0, err
+	}
+	m, err := creationSize(ref.Creation, c.legacyNodeTerms())
+	if err != nil {
+		return 0, err
+	}
+	return 3 + n + m + 4*len(ref.Id), nil
+}
+
+func tupleHeadSize(n int) int {
+	if n <= math.MaxUint8 {
+		return 2
+	}
+	return 5
+}
@@ -0,0 +1,80 @@
+package etf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// An Encoder writes terms, each one as erlang:term_to_binary would
+// produce it, to an output stream.
+type Encoder struct {
+	// Context holds the encoding options, such as TargetOTP,
+	// Compression and MapKeys.
+	Context Context
+
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the encoding of v to the stream. Nothing is written
+// if v can't be encoded.
+func (e *Encoder) Encode(v interface{}) (err error) {
+	e.buf.Reset()
+	e.buf.WriteByte(EtVersion)
+	if err = e.Context.Write(&e.buf, v); err != nil {
+		return
+	}
+	_, err = e.w.Write(e.buf.Bytes())
+	return
+}
+
+// A Decoder reads a sequence of terms, such as the concatenated
+// outputs of erlang:term_to_binary, from an input stream.
+type Decoder struct {
+	// Context holds the decoding options.
+	Context Context
+
+	r *bufio.Reader
+}
+
+// NewDecoder returns a new decoder that reads from r. The decoder
+// buffers its input and may read data from r beyond the terms
+// requested.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next term from the stream and stores it in the
+// value pointed to by v, as DecodeTerm does. It returns io.EOF when
+// the stream ends between terms.
+func (d *Decoder) Decode(v interface{}) (err error) {
+	var version byte
+	if version, err = d.r.ReadByte(); err != nil {
+		return
+	} else if version != EtVersion {
+		return fmt.Errorf("read: version %d not supported", version)
+	}
+
+	var term Term
+	if term, err = d.Context.Read(d.r); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+
+	return DecodeTerm(term, v)
+}
+
+// More reports whether there is another term to decode.
+func (d *Decoder) More() bool {
+	_, err := d.r.Peek(1)
+	return err == nil
+}
@@ -0,0 +1,131 @@
+package etf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	type pair struct {
+		K Atom
+		V int
+	}
+
+	w := new(bytes.Buffer)
+	enc := NewEncoder(w)
+	terms := []interface{}{pair{"a", 1}, pair{"b", 2}, string(bytes.Repeat([]byte{'x'}, 1000))}
+	for i, term := range terms {
+		if i == 2 {
+			enc.Context.Compression = zlib.BestSpeed
+		}
+		if err := enc.Encode(term); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// nothing is written for terms that fail
+	l := w.Len()
+	if err := enc.Encode(Tuple{1, make(chan int)}); err == nil {
+		t.Error("err == nil")
+	} else if w.Len() != l {
+		t.Errorf("expected %d bytes, got %d", l, w.Len())
+	}
+
+	dec := NewDecoder(w)
+	var p pair
+	if err := dec.Decode(&p); err != nil {
+		t.Error(err)
+	} else if p != terms[0] {
+		t.Errorf("expected %v, got %v", terms[0], p)
+	}
+	var term Term
+	if err := dec.Decode(&term); err != nil {
+		t.Error(err)
+	} else if exp := (Tuple{Atom("b"), 2}); !reflect.DeepEqual(term, exp) {
+		t.Errorf("expected %v, got %v", exp, term)
+	}
+	var s string
+	if !dec.More() {
+		t.Error("expected more terms")
+	} else if err := dec.Decode(&s); err != nil {
+		t.Error(err)
+	} else if s != terms[2] {
+		t.Errorf("expected %v, got %v", terms[2], s)
+	}
+
+	if dec.More() {
+		t.Error("expected no more terms")
+	} else if err := dec.Decode(&term); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	for _, in := range [][]byte{
+		{131, 97, 1, 131},
+		{131, 97, 1, 131, 104, 2, 97},
+		{131, 97, 1, 130, 97, 1},
+	} {
+		dec := NewDecoder(bytes.NewReader(in))
+		var term Term
+		if err := dec.Decode(&term); err != nil {
+			t.Errorf("%v: %v", in, err)
+		} else if err = dec.Decode(&term); err == nil || err == io.EOF {
+			t.Errorf("%v: expected error, got %v", in, err)
+		}
+	}
+}
+
+func TestStreamStrings(t *testing.T) {
+	strs := []string{"été", "日本", ""}
+	tests := []struct {
+		strings   StringFormat
+		charlists CharlistMode
+	}{
+		{StringDefault, CharlistsRaw},
+		{StringCharlist, CharlistsAsString},
+		{StringList, CharlistsAsString},
+		{StringBinary, CharlistsAsString},
+	}
+
+	for _, test := range tests {
+		w := new(bytes.Buffer)
+		enc := NewEncoder(w)
+		enc.Context.Strings = test.strings
+		for _, s := range strs {
+			if err := enc.Encode(s); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		dec := NewDecoder(w)
+		dec.Context.Charlists = test.charlists
+		dec.Context.UnicodeCharlists = true
+		for _, exp := range strs {
+			var s string
+			if err := dec.Decode(&s); err != nil {
+				t.Errorf("%v: %v", test, err)
+			} else if s != exp {
+				t.Errorf("%v: expected %q, got %q", test, exp, s)
+			}
+		}
+	}
+
+	// "hé" from Erlang, as STRING_EXT and as LIST_EXT
+	for _, in := range [][]byte{
+		{131, 107, 0, 2, 104, 233},
+		{131, 108, 0, 0, 0, 2, 97, 104, 97, 233, 106},
+	} {
+		dec := NewDecoder(bytes.NewReader(in))
+		dec.Context.Charlists = CharlistsAsString
+		var s string
+		if err := dec.Decode(&s); err != nil {
+			t.Errorf("%v: %v", in, err)
+		} else if s != "hé" {
+			t.Errorf("%v: expected %q, got %q", in, "hé", s)
+		}
+	}
+}
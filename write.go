@@ -2,355 +2,890 @@ package etf
 
 import (
 	"bytes"
+	"compress/zlib"
 	"fmt"
 	"io"
 	"math"
 	"math/big"
 	"reflect"
+	"sync"
+	"unicode/utf8"
 )
 
 type ErrUnknownType struct {
 	t reflect.Type
 }
 
-func (c *Context) WriteDist(w io.Writer, _ []Term) (err error) {
-	// TODO: now it is just stub dist header, add cache functionality
-	_, err = w.Write([]byte{EtDist, 0})
+var atomType = reflect.TypeOf(Atom(""))
+
+// Marshaler is implemented by types that encode themselves. MarshalETF
+// returns the encoding of a single term, without the version byte.
+type Marshaler interface {
+	MarshalETF() ([]byte, error)
+}
+
+// TermMarshaler is implemented by types that are encoded as some other
+// term, which MarshalTerm returns.
+type TermMarshaler interface {
+	MarshalTerm() (Term, error)
+}
+
+// WriteDist writes the distribution header for a message made of terms
+// (the control message and, optionally, the message itself), putting
+// their atoms in the peer's atom cache. The terms must then be written
+// with Write, which encodes cached atoms as references into the header.
+// The references stay in effect until the next call to WriteDist.
+func (c *Context) WriteDist(w io.Writer, terms []Term) (err error) {
+	if c.out == nil {
+		c.out = new(outCache)
+	}
+	c.out.refs = nil
+
+	var atoms []Atom
+	if atoms, err = c.scanAtoms(terms); err != nil {
+		return
+	}
+
+	entries := c.out.assign(atoms)
+	n := len(entries)
+	if n == 0 {
+		_, err = w.Write([]byte{EtDist, 0})
+		return
+	}
+
+	longAtoms := false
+	for _, e := range entries {
+		if e.isNew && len(e.atom) > math.MaxUint8 {
+			longAtoms = true
+		}
+	}
+
+	// $DN, flags for N refs and the long atoms flag packed in half bytes
+	head := make([]byte, 2+n/2+1)
+	head[0], head[1] = EtDist, byte(n)
+	flags := head[2:]
+	for i, e := range entries {
+		v := byte(e.slot>>8) & 0x07
+		if e.isNew {
+			v |= 0x08
+		}
+		flags[i/2] |= v << (4 * uint(i&0x01))
+	}
+	if longAtoms {
+		flags[n/2] |= 0x01 << (4 * uint(n&0x01))
+	}
+
+	for _, e := range entries {
+		head = append(head, byte(e.slot))
+		if !e.isNew {
+			continue
+		}
+		if size := len(e.atom); longAtoms {
+			head = append(head, byte(size>>8), byte(size))
+		} else {
+			head = append(head, byte(size))
+		}
+		head = append(head, e.atom...)
+	}
+
+	if _, err = w.Write(head); err == nil {
+		c.out.commit(entries)
+	}
+
 	return
 }
 
-func (c *Context) Write(w io.Writer, term interface{}) (err error) {
+// Marshal returns the external term format encoding of v, as
+// produced by erlang:term_to_binary.
+//
+// Structs are encoded as tuples of their exported fields, in order.
+// The encoding can be adjusted with `etf:"name,options"` field tags:
+//
+//	type Person struct {
+//		_    struct{} `etf:"person"`
+//		Name string   `etf:"name,binary"`
+//		Age  int      `etf:"age"`
+//		Temp []byte   `etf:"-"`
+//	}
+//
+// A tag of "-" omits the field. The atom, binary and charlist options
+// encode a string field as an atom, BINARY_EXT or a charlist. The name
+// is the field's key, an atom, when the struct is encoded as a map; it
+// defaults to the field name.
+//
+// The tag of a blank (_) field applies to the whole struct. Its name
+// is a record tag put in front of the fields, so that Person above is
+// encoded as {person, Name, Age}. The map option encodes the struct as
+// a map instead of a tuple.
+func Marshal(v interface{}) ([]byte, error) {
+	b, err := new(Context).AppendTerm([]byte{EtVersion}, v)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Write encodes term to w, compressing it if c.Compression says so.
+// The encoding is written with a single call to w.Write, and not at all
+// if it fails.
+func (c *Context) Write(w io.Writer, term interface{}) error {
+	buf := writeBufs.Get().(*[]byte)
+	b, err := c.AppendTerm((*buf)[:0], term)
+	if err == nil {
+		_, err = w.Write(b)
+	}
+	if cap(b) <= maxPooledBuf {
+		*buf = b
+		writeBufs.Put(buf)
+	}
+	return err
+}
+
+// writeBufs holds the buffers Write encodes into, which are only kept
+// for reuse up to maxPooledBuf bytes.
+var writeBufs = sync.Pool{
+	New: func() interface{} { return new([]byte) },
+}
+
+const maxPooledBuf = 64 << 10
+
+// AppendTerm appends the encoding of term that Write would write to
+// dst, and returns the extended slice, or dst and an error.
+//
+// Given room for EncodedSize(term) bytes, it doesn't allocate, except
+// to compress and for terms that must first be converted to others:
+// Go maps, and struct fields and strings encoded in some other format.
+func (c *Context) AppendTerm(dst []byte, term Term) ([]byte, error) {
+	b, err := c.appendTerm(dst, term)
+	if err != nil {
+		return dst, err
+	} else if c.Compression == 0 || len(b)-len(dst) < c.CompressThreshold {
+		return b, nil
+	}
+
+	z, err := c.compress(b[len(dst):])
+	if err != nil {
+		return dst, err
+	} else if z == nil {
+		return b, nil
+	}
+	return append(b[:len(dst)], z...), nil
+}
+
+// compress returns b as COMPRESSED_EXT, or nil if that doesn't make it
+// smaller, in which case term_to_binary doesn't bother either.
+func (c *Context) compress(b []byte) ([]byte, error) {
+	size := len(b)
+	if int64(size) > math.MaxUint32 {
+		return nil, fmt.Errorf("term is too big to compress (%d bytes)", size)
+	}
+
+	// $PSSSSZ…
+	buf := bytes.NewBuffer([]byte{
+		ettCompressed,
+		byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size),
+	})
+	zw, err := zlib.NewWriterLevel(buf, c.Compression)
+	if err != nil {
+		return nil, err
+	} else if _, err = zw.Write(b); err != nil {
+		return nil, err
+	} else if err = zw.Close(); err != nil {
+		return nil, err
+	}
+
+	if buf.Len() >= size {
+		return nil, nil
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *Context) appendTerm(b []byte, term interface{}) ([]byte, error) {
 	switch v := term.(type) {
+	case Marshaler:
+		e, err := v.MarshalETF()
+		if err != nil {
+			return b, err
+		}
+		return append(b, e...), nil
+	case TermMarshaler:
+		t, err := v.MarshalTerm()
+		if err != nil {
+			return b, err
+		}
+		return c.appendTerm(b, t)
 	case bool:
-		err = c.writeBool(w, v)
+		return c.appendBool(b, v)
 	case int8, int16, int32, int64, int:
-		err = c.writeInt(w, reflect.ValueOf(term).Int())
+		return c.appendInt(b, reflect.ValueOf(term).Int()), nil
 	case uint8, uint16, uint32, uint64, uintptr, uint:
-		err = c.writeUint(w, reflect.ValueOf(term).Uint())
+		return c.appendUint(b, reflect.ValueOf(term).Uint()), nil
 	case *big.Int:
-		err = c.writeBigInt(w, v)
+		return c.appendBigInt(b, v)
 	case string:
-		err = c.writeString(w, v)
+		return c.appendString(b, v)
 	case []byte:
-		err = c.writeBinary(w, v)
+		return c.appendBinary(b, v)
+	case Charlist:
+		return c.appendCharlist(b, v)
+	case BitString:
+		return c.appendBitString(b, v)
 	case float64:
-		err = c.writeFloat(w, v)
+		return c.appendFloat(b, v), nil
 	case float32:
-		err = c.writeFloat(w, float64(v))
+		return c.appendFloat(b, float64(v)), nil
 	case Atom:
-		err = c.writeAtom(w, v)
+		return c.appendAtom(b, v)
 	case Pid:
-		err = c.writePid(w, v)
+		return c.appendPid(b, v)
+	case Port:
+		return c.appendPort(b, v)
 	case Tuple:
-		err = c.writeTuple(w, v)
+		return c.appendTuple(b, v)
 	case Ref:
-		err = c.writeRef(w, v)
-	default:
-		rv := reflect.ValueOf(v)
-		switch rv.Kind() {
-		case reflect.Struct:
-			err = c.writeRecord(w, term)
-		case reflect.Array, reflect.Slice:
-			err = c.writeList(w, term)
-		case reflect.Ptr:
-			err = c.Write(w, rv.Elem())
-		//case reflect.Map // FIXME
-		default:
-			err = &ErrUnknownType{rv.Type()}
+		return c.appendRef(b, v)
+	case Map:
+		return c.appendMap(b, v)
+	case ImproperList:
+		return c.appendImproperList(b, v)
+	case Function:
+		return c.appendFunction(b, v)
+	case Export:
+		return c.appendExport(b, v)
+	}
+
+	rv := reflect.ValueOf(term)
+	switch rv.Kind() {
+	case reflect.Struct:
+		return c.appendStruct(b, rv)
+	case reflect.Array, reflect.Slice:
+		return c.appendList(b, rv)
+	case reflect.Map:
+		return c.appendMap(b, c.goMap(rv))
+	case reflect.Ptr:
+		if !rv.IsNil() {
+			return c.appendValue(b, rv.Elem())
 		}
 	}
+	return b, &ErrUnknownType{reflect.TypeOf(term)}
+}
 
-	return
+// appendValue appends the encoding of v, without converting it to an
+// interface, which may allocate, if it is of a predeclared type.
+func (c *Context) appendValue(b []byte, v reflect.Value) ([]byte, error) {
+	if t := v.Type(); t.PkgPath() == "" && t.Name() != "" {
+		switch v.Kind() {
+		case reflect.Bool:
+			return c.appendBool(b, v.Bool())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return c.appendInt(b, v.Int()), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return c.appendUint(b, v.Uint()), nil
+		case reflect.Float32, reflect.Float64:
+			return c.appendFloat(b, v.Float()), nil
+		case reflect.String:
+			return c.appendString(b, v.String())
+		}
+	} else if t == atomType {
+		return c.appendAtom(b, Atom(v.String()))
+	}
+	return c.appendTerm(b, v.Interface())
 }
 
 func (e *ErrUnknownType) Error() string {
+	if e.t == nil {
+		return "write: can't encode nil"
+	}
 	return fmt.Sprintf("write: can't encode type \"%s\"", e.t.Name())
 }
 
-func (c *Context) writeAtom(w io.Writer, atom Atom) (err error) {
-	switch size := len(atom); {
-	case size <= math.MaxUint8:
+func (c *Context) appendAtom(b []byte, atom Atom) ([]byte, error) {
+	if c.out != nil && c.out.scanning {
+		c.out.note(atom)
+	}
+	if idx, ok := c.atomRef(atom); ok {
+		// $RI
+		return append(b, ettCacheRef, idx), nil
+	}
+
+	tag, err := c.atomTag(atom)
+	if err != nil {
+		return b, err
+	}
+
+	switch size := len(atom); tag {
+	case ettSmallAtom:
 		// $sL…
-		if _, err = w.Write([]byte{ettSmallAtom, byte(size)}); err == nil {
-			_, err = io.WriteString(w, string(atom))
+		b = append(b, tag, byte(utf8.RuneCountInString(string(atom))))
+		for _, r := range string(atom) {
+			b = append(b, byte(r))
 		}
+		return b, nil
 
-	case size <= math.MaxUint16:
-		// $dLL…
-		_, err = w.Write([]byte{ettAtom, byte(size >> 8), byte(size)})
-		if err == nil {
-			_, err = io.WriteString(w, string(atom))
-		}
+	case ettSmallAtomUTF8:
+		// $wL…
+		b = append(b, tag, byte(size))
 
 	default:
-		err = fmt.Errorf("atom is too big (%d bytes)", size)
+		// $vLL…
+		b = append(b, tag, byte(size>>8), byte(size))
 	}
 
+	return append(b, atom...), nil
+}
+
+// atomRef returns the atom cache reference that atom is encoded as in
+// the current message, if any.
+func (c *Context) atomRef(atom Atom) (idx uint8, ok bool) {
+	if c.out != nil {
+		idx, ok = c.out.refs[atom]
+	}
 	return
 }
 
-func (c *Context) writeBigInt(w io.Writer, x *big.Int) (err error) {
-	sign := 0
+// atomTag checks that atom can be encoded and returns the tag to encode
+// it with, unless it is encoded as an atom cache reference.
+func (c *Context) atomTag(atom Atom) (byte, error) {
+	if !utf8.ValidString(string(atom)) {
+		return 0, fmt.Errorf("atom is not valid UTF-8 (%q)", atom)
+	} else if n := utf8.RuneCountInString(string(atom)); n > maxAtomChars {
+		return 0, fmt.Errorf("atom is too big (%d characters)", n)
+	}
+
+	switch {
+	case c.TargetOTP != 0 && c.TargetOTP < 26 && isLatin1(string(atom)):
+		// before OTP 26, Latin-1 atoms are encoded as such
+		return ettSmallAtom, nil
+	case len(atom) <= math.MaxUint8:
+		return ettSmallAtomUTF8, nil
+	}
+	return ettAtomUTF8, nil
+}
+
+// isLatin1 reports whether s is all Latin-1 characters.
+func isLatin1(s string) bool {
+	for _, r := range s {
+		if r > math.MaxUint8 {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Context) appendBigInt(b []byte, x *big.Int) ([]byte, error) {
+	var sign byte
 	if x.Sign() < 0 {
 		sign = 1
 	}
 
-	bytes := reverse(new(big.Int).Abs(x).Bytes())
-
-	switch size := int64(len(bytes)); {
+	switch size := bigIntSize(x); {
 	case size <= math.MaxUint8:
 		// $nAS…
-		_, err = w.Write([]byte{ettSmallBig, byte(size), byte(sign)})
+		b = append(b, ettSmallBig, byte(size), sign)
 
-	case size <= math.MaxUint32:
+	case int64(size) <= math.MaxUint32:
 		// $oAAAAS…
-		_, err = w.Write([]byte{
-			ettLargeBig,
-			byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size),
-			byte(sign),
-		})
+		b = append(b, ettLargeBig)
+		b = be.AppendUint32(b, uint32(size))
+		b = append(b, sign)
 
 	default:
-		err = fmt.Errorf("bad big int size (%d)", size)
+		return b, fmt.Errorf("bad big int size (%d)", size)
 	}
 
-	if err == nil {
-		_, err = w.Write(bytes)
-	}
+	// the magnitude, little-endian
+	n := len(b)
+	b = append(b, make([]byte, bigIntSize(x))...)
+	x.FillBytes(b[n:])
+	reverse(b[n:])
+	return b, nil
+}
 
-	return
+// bigIntSize returns the size in bytes of the magnitude of x.
+func bigIntSize(x *big.Int) int {
+	return (x.BitLen() + 7) / 8
 }
 
-func (c *Context) writeBinary(w io.Writer, bytes []byte) (err error) {
-	switch size := int64(len(bytes)); {
-	case size <= math.MaxUint32:
-		// $mLLLL…
-		data := []byte{
-			ettBinary,
-			byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size),
-		}
-		if _, err = w.Write(data); err == nil {
-			_, err = w.Write(bytes)
-		}
+func (c *Context) appendBinary(b []byte, bytes []byte) ([]byte, error) {
+	b, err := appendBinaryHead(b, len(bytes))
+	if err != nil {
+		return b, err
+	}
+	return append(b, bytes...), nil
+}
 
-	default:
-		err = fmt.Errorf("bad binary size (%d)", size)
+func appendBinaryHead(b []byte, size int) ([]byte, error) {
+	if int64(size) > math.MaxUint32 {
+		return b, fmt.Errorf("bad binary size (%d)", size)
 	}
 
-	return
+	// $mLLLL…
+	b = append(b, ettBinary)
+	return be.AppendUint32(b, uint32(size)), nil
 }
 
-func (c *Context) writeBool(w io.Writer, b bool) (err error) {
-	// $sL…
-	if b {
-		_, err = w.Write([]byte{ettSmallAtom, 4, 't', 'r', 'u', 'e'})
-	} else {
-		_, err = w.Write([]byte{ettSmallAtom, 5, 'f', 'a', 'l', 's', 'e'})
+// appendBitString appends s as BIT_BINARY_EXT, or as BINARY_EXT if it
+// is empty.
+func (c *Context) appendBitString(b []byte, s BitString) ([]byte, error) {
+	if len(s.Bytes) == 0 {
+		return c.appendBinary(b, nil)
+	} else if s.Bits == 0 || s.Bits > 8 {
+		return b, fmt.Errorf("bad bitstring bits (%d)", s.Bits)
 	}
 
-	return
+	size := len(s.Bytes)
+	if int64(size) > math.MaxUint32 {
+		return b, fmt.Errorf("bad bitstring size (%d)", size)
+	}
+
+	// $MLLLLB…
+	b = append(b, ettBitBinary)
+	b = be.AppendUint32(b, uint32(size))
+	b = append(b, s.Bits)
+	b = append(b, s.Bytes[:size-1]...)
+	return append(b, s.Bytes[size-1]&(^byte(0)<<(8-s.Bits))), nil
 }
 
-func (c *Context) writeFloat(w io.Writer, f float64) (err error) {
-	if _, err = w.Write([]byte{ettNewFloat}); err == nil {
-		fb := math.Float64bits(f)
-		_, err = w.Write([]byte{
-			byte(fb >> 56), byte(fb >> 48), byte(fb >> 40), byte(fb >> 32),
-			byte(fb >> 24), byte(fb >> 16), byte(fb >> 8), byte(fb),
-		})
+func (c *Context) appendBool(b []byte, v bool) ([]byte, error) {
+	if v {
+		return c.appendAtom(b, Atom("true"))
 	}
-	return
+	return c.appendAtom(b, Atom("false"))
 }
 
-func (c *Context) writeInt(w io.Writer, x int64) (err error) {
+func (c *Context) appendFloat(b []byte, f float64) []byte {
+	// $FFFFFFFFF
+	b = append(b, ettNewFloat)
+	return be.AppendUint64(b, math.Float64bits(f))
+}
+
+func (c *Context) appendInt(b []byte, x int64) []byte {
 	switch {
 	case x >= 0 && x <= math.MaxUint8:
 		// $aI
-		_, err = w.Write([]byte{ettSmallInteger, byte(x)})
+		return append(b, ettSmallInteger, byte(x))
 
 	case x >= math.MinInt32 && x <= math.MaxInt32:
 		// $bIIII
-		x := int32(x)
-		_, err = w.Write([]byte{
-			ettInteger,
-			byte(x >> 24), byte(x >> 16), byte(x >> 8), byte(x),
-		})
-
-	default:
-		err = c.writeBigInt(w, big.NewInt(x))
+		b = append(b, ettInteger)
+		return be.AppendUint32(b, uint32(x))
 	}
 
-	return
+	// $nAS…, with up to 8 bytes of magnitude
+	var sign byte
+	u := uint64(x)
+	if x < 0 {
+		sign, u = 1, -u
+	}
+	return appendSmallBig(b, sign, u)
 }
 
-func (c *Context) writeUint(w io.Writer, x uint64) (err error) {
+func (c *Context) appendUint(b []byte, x uint64) []byte {
 	switch {
 	case x <= math.MaxUint8:
 		// $aI
-		_, err = w.Write([]byte{ettSmallInteger, byte(x)})
+		return append(b, ettSmallInteger, byte(x))
 
 	case x <= math.MaxInt32:
 		// $bIIII
-		_, err = w.Write([]byte{
-			ettInteger,
-			byte(x >> 24), byte(x >> 16), byte(x >> 8), byte(x),
-		})
+		b = append(b, ettInteger)
+		return be.AppendUint32(b, uint32(x))
+	}
+
+	return appendSmallBig(b, 0, x)
+}
 
+// appendSmallBig appends u as SMALL_BIG_EXT, as big.Int would be.
+func appendSmallBig(b []byte, sign byte, u uint64) []byte {
+	size := smallBigSize(u)
+	b = append(b, ettSmallBig, byte(size), sign)
+	for i := 0; i < size; i++ {
+		b = append(b, byte(u>>(8*uint(i))))
+	}
+	return b
+}
+
+// smallBigSize returns the size in bytes of u.
+func smallBigSize(u uint64) int {
+	n := 0
+	for ; u > 0; u >>= 8 {
+		n++
+	}
+	return n
+}
+
+func (c *Context) appendPid(b []byte, p Pid) ([]byte, error) {
+	tag := byte(ettNewPid)
+	if c.legacyNodeTerms() {
+		tag = ettPid
+	}
+
+	b = append(b, tag)
+	b, err := c.appendAtom(b, p.Node)
+	if err != nil {
+		return b, err
+	}
+
+	b = be.AppendUint32(b, p.Id)
+	b = be.AppendUint32(b, p.Serial)
+	return appendCreation(b, p.Creation, tag == ettPid)
+}
+
+func (c *Context) appendPort(b []byte, p Port) ([]byte, error) {
+	var tag byte
+	switch {
+	case p.Id > math.MaxUint32:
+		if c.TargetOTP != 0 && c.TargetOTP < 24 {
+			return b, fmt.Errorf("port id %d needs OTP 24", p.Id)
+		}
+		tag = ettV4Port
+	case c.legacyNodeTerms():
+		tag = ettPort
 	default:
-		err = c.writeBigInt(w, new(big.Int).SetUint64(x))
+		tag = ettNewPort
 	}
 
-	return
+	b = append(b, tag)
+	b, err := c.appendAtom(b, p.Node)
+	if err != nil {
+		return b, err
+	}
+
+	if tag == ettV4Port {
+		b = be.AppendUint64(b, p.Id)
+	} else {
+		b = be.AppendUint32(b, uint32(p.Id))
+	}
+	return appendCreation(b, p.Creation, tag == ettPort)
 }
 
-func (c *Context) writePid(w io.Writer, p Pid) (err error) {
-	if _, err = w.Write([]byte{ettPid}); err != nil {
-		return
-	} else if err = c.writeAtom(w, p.Node); err != nil {
-		return
+// appendCreation appends the node creation of a pid, port or
+// reference, which is a single byte in the pre-OTP 19 encodings.
+func appendCreation(b []byte, creation uint32, legacy bool) ([]byte, error) {
+	if !legacy {
+		return be.AppendUint32(b, creation), nil
+	} else if creation > math.MaxUint8 {
+		return b, fmt.Errorf("creation %d needs OTP 19", creation)
 	}
+	return append(b, byte(creation)), nil
+}
 
-	_, err = w.Write([]byte{
-		0, 0, byte(p.Id >> 8), byte(p.Id),
-		byte(p.Serial >> 24),
-		byte(p.Serial >> 16),
-		byte(p.Serial >> 8),
-		byte(p.Serial),
-		p.Creation,
-	})
+// legacyNodeTerms reports whether pids, ports and references
+// must use the encodings that predate OTP 19.
+func (c *Context) legacyNodeTerms() bool {
+	return c.TargetOTP != 0 && c.TargetOTP < 19
+}
 
-	return
+func (c *Context) appendString(b []byte, s string) ([]byte, error) {
+	return c.appendStringAs(b, s, c.Strings)
 }
 
-func (c *Context) writeString(w io.Writer, s string) (err error) {
-	switch size := len(s); {
-	case size <= math.MaxUint16:
+// appendStringAs appends s as stringTerm(s, f), without making that
+// term when it can.
+func (c *Context) appendStringAs(b []byte, s string, f StringFormat) (_ []byte, err error) {
+	switch f {
+	case StringDefault:
+		size := len(s)
+		if size > math.MaxUint16 {
+			return b, fmt.Errorf("string is too big (%d bytes)", size)
+		}
 		// $kLL…
-		_, err = w.Write([]byte{ettString, byte(size >> 8), byte(size)})
-		if err == nil {
-			_, err = w.Write([]byte(s))
+		b = append(b, ettString, byte(size>>8), byte(size))
+		return append(b, s...), nil
+
+	case StringBinary:
+		if b, err = appendBinaryHead(b, len(s)); err != nil {
+			return
 		}
+		return append(b, s...), nil
 
-	default:
-		err = fmt.Errorf("string is too big (%d bytes)", size)
+	case StringAtom:
+		return c.appendAtom(b, Atom(s))
 	}
 
-	return
+	return c.appendTerm(b, stringTerm(s, f))
 }
 
-func (c *Context) writeList(w io.Writer, l interface{}) (err error) {
-	rv := reflect.ValueOf(l)
-	n := rv.Len()
-	_, err = w.Write([]byte{
-		ettList,
-		byte(n >> 24),
-		byte(n >> 16),
-		byte(n >> 8),
-		byte(n),
-	})
+// appendCharlist appends l as STRING_EXT if it can, as LIST_EXT
+// otherwise.
+func (c *Context) appendCharlist(b []byte, l Charlist) ([]byte, error) {
+	n := len(l)
+	if n == 0 {
+		return append(b, ettNil), nil
+	} else if !l.isString() {
+		return c.appendList(b, reflect.ValueOf(l))
+	}
 
-	if err != nil {
-		return
+	// $kLL…
+	b = append(b, ettString, byte(n>>8), byte(n))
+	for _, r := range l {
+		b = append(b, byte(r))
+	}
+	return b, nil
+}
+
+// isString reports whether l fits in STRING_EXT.
+func (l Charlist) isString() bool {
+	if len(l) > math.MaxUint16 {
+		return false
 	}
+	for _, r := range l {
+		if r < 0 || r > math.MaxUint8 {
+			return false
+		}
+	}
+	return true
+}
 
+func (c *Context) appendList(b []byte, l reflect.Value) (_ []byte, err error) {
+	n := l.Len()
+	if n == 0 {
+		// $j, like term_to_binary([])
+		return append(b, ettNil), nil
+	}
+
+	b = AppendListHead(b, n)
 	for i := 0; i < n; i++ {
-		v := rv.Index(i).Interface()
-		if err = c.Write(w, v); err != nil {
+		if b, err = c.appendValue(b, l.Index(i)); err != nil {
 			return
 		}
 	}
 
-	_, err = w.Write([]byte{ettNil})
-
-	return
+	return AppendNil(b), nil
 }
 
-func (c *Context) writeRecord(w io.Writer, r interface{}) (err error) {
-	rv := reflect.ValueOf(r)
-	n := rv.NumField()
-	buf := new(bytes.Buffer)
-	arity := 0
+// appendImproperList appends the elements of l followed by its tail,
+// instead of NIL_EXT.
+func (c *Context) appendImproperList(b []byte, l ImproperList) (_ []byte, err error) {
+	n := len(l.Elems)
+	if n == 0 {
+		return c.appendTerm(b, l.Tail)
+	}
 
-	for i := 0; i < n; i++ {
-		if f := rv.Field(i); f.CanInterface() {
-			if err = c.Write(buf, f.Interface()); err != nil {
-				return
-			}
-			arity++
+	b = AppendListHead(b, n)
+	for _, v := range l.Elems {
+		if b, err = c.appendTerm(b, v); err != nil {
+			return
 		}
 	}
 
-	if arity <= math.MaxUint8 {
-		_, err = w.Write([]byte{ettSmallTuple, byte(arity)})
-	} else {
-		_, err = w.Write([]byte{
-			ettLargeTuple,
-			byte(arity >> 24),
-			byte(arity >> 16),
-			byte(arity >> 8),
-			byte(arity),
-		})
+	return c.appendTerm(b, l.Tail)
+}
+
+// goMap converts a Go map to a Map sorted in Erlang map key order,
+// encoding string keys as c.MapKeys says.
+func (c *Context) goMap(rv reflect.Value) Map {
+	m := make(Map, 0, rv.Len())
+	for _, k := range rv.MapKeys() {
+		m = append(m, MapElem{c.mapKey(k), rv.MapIndex(k).Interface()})
 	}
+	return sortedMap(m)
+}
 
-	if err == nil {
-		_, err = buf.WriteTo(w)
+func (c *Context) mapKey(k reflect.Value) Term {
+	if k.Kind() == reflect.Interface && !k.IsNil() {
+		k = k.Elem()
+	}
+	if k.Kind() != reflect.String || k.Type() == atomType {
+		return k.Interface()
 	}
 
-	return
+	f := c.MapKeys
+	if f == StringDefault {
+		// the key must sort as what it is encoded as
+		f = c.Strings
+	}
+	return stringTerm(k.String(), f)
 }
 
-func (c *Context) writeRef(w io.Writer, ref Ref) (err error) {
-	n := len(ref.Id)
-	_, err = w.Write([]byte{ettNewRef, byte(n >> 8), byte(n)})
-	if err != nil {
+// stringTerm returns the term that s is encoded as in format f. With
+// StringDefault, that is s itself, encoded as Context.Strings says.
+func stringTerm(s string, f StringFormat) Term {
+	switch f {
+	case StringCharlist:
+		return Charlist(s)
+	case StringBinary:
+		return []byte(s)
+	case StringAtom:
+		return Atom(s)
+	case StringList:
+		l := make(List, 0, len(s))
+		for _, r := range s {
+			l = append(l, int(r))
+		}
+		return l
+	}
+	return s
+}
+
+func (c *Context) appendMap(b []byte, m Map) (_ []byte, err error) {
+	b = AppendMapHead(b, len(m))
+	for _, e := range m {
+		if b, err = c.appendTerm(b, e.Key); err != nil {
+			return
+		} else if b, err = c.appendTerm(b, e.Value); err != nil {
+			return
+		}
+	}
+
+	return b, nil
+}
+
+// appendFunction appends f as NEW_FUN_EXT, filling in its size once
+// the nested terms are encoded.
+func (c *Context) appendFunction(b []byte, f Function) (_ []byte, err error) {
+	if f.isOld() {
+		return c.appendOldFunction(b, f)
+	}
+
+	// $pSSSSAUUUUUUUUUUUUUUUUIIIINNNN…
+	start := len(b)
+	b = append(b, ettNewFun, 0, 0, 0, 0, f.Arity)
+	b = append(b, f.Unique[:]...)
+	b = be.AppendUint32(b, f.Index)
+	b = be.AppendUint32(b, uint32(len(f.FreeVars)))
+
+	if b, err = c.appendAtom(b, f.Module); err != nil {
 		return
 	}
-	if err = c.writeAtom(w, ref.Node); err != nil {
+	b = c.appendUint(b, uint64(f.OldIndex))
+	b = c.appendUint(b, uint64(f.OldUnique))
+	if b, err = c.appendPid(b, f.Pid); err != nil {
 		return
 	}
-	if _, err = w.Write([]byte{ref.Creation}); err != nil {
+	for _, v := range f.FreeVars {
+		if b, err = c.appendTerm(b, v); err != nil {
+			return
+		}
+	}
+
+	size := len(b) - start - 1
+	if int64(size) > math.MaxUint32 {
+		return b, fmt.Errorf("bad fun size (%d)", size)
+	}
+	be.PutUint32(b[start+1:], uint32(size))
+	return b, nil
+}
+
+// appendOldFunction appends f as FUN_EXT.
+func (c *Context) appendOldFunction(b []byte, f Function) (_ []byte, err error) {
+	// $uFFFFP…M…i…u…[V…]
+	b = append(b, ettFun)
+	b = be.AppendUint32(b, uint32(len(f.FreeVars)))
+	if b, err = c.appendPid(b, f.Pid); err != nil {
+		return
+	} else if b, err = c.appendAtom(b, f.Module); err != nil {
 		return
 	}
-	for _, v := range ref.Id {
-		b := []byte{
-			byte(v >> 24),
-			byte(v >> 16),
-			byte(v >> 8),
-			byte(v),
+	b = c.appendUint(b, uint64(f.OldIndex))
+	b = c.appendUint(b, uint64(f.OldUnique))
+	for _, v := range f.FreeVars {
+		if b, err = c.appendTerm(b, v); err != nil {
+			return
+		}
+	}
+	return b, nil
+}
+
+func (c *Context) appendExport(b []byte, e Export) (_ []byte, err error) {
+	// $qMFA
+	b = append(b, ettExport)
+	if b, err = c.appendAtom(b, e.Module); err != nil {
+		return
+	} else if b, err = c.appendAtom(b, e.Function); err != nil {
+		return
+	}
+	return append(b, ettSmallInteger, e.Arity), nil
+}
+
+// appendStruct appends the tuple or map that the struct rv is encoded
+// as, straight from its fields.
+func (c *Context) appendStruct(b []byte, rv reflect.Value) (_ []byte, err error) {
+	si := getStructInfo(rv.Type())
+	if si.asMap {
+		// with the fields in key order
+		b = AppendMapHead(b, len(si.fields))
+		for i := range si.fields {
+			f := &si.fields[i]
+			if b, err = c.appendAtom(b, f.key); err != nil {
+				return
+			} else if b, err = c.appendField(b, f, rv); err != nil {
+				return
+			}
 		}
-		if _, err = w.Write(b); err != nil {
+		return b, nil
+	}
+
+	n := len(si.fields)
+	if si.hasTag {
+		n++
+	}
+	b = appendTupleHead(b, n)
+	if si.hasTag {
+		if b, err = c.appendAtom(b, si.tag); err != nil {
+			return
+		}
+	}
+	for i := range si.fields {
+		if b, err = c.appendField(b, &si.fields[i], rv); err != nil {
 			return
 		}
 	}
 
-	return
+	return b, nil
 }
 
-func (c *Context) writeTuple(w io.Writer, tuple Tuple) (err error) {
-	n := len(tuple)
-	if n <= math.MaxUint8 {
-		_, err = w.Write([]byte{ettSmallTuple, byte(n)})
-	} else {
-		_, err = w.Write([]byte{
-			ettLargeTuple,
-			byte(n >> 24),
-			byte(n >> 16),
-			byte(n >> 8),
-			byte(n),
-		})
+// appendField appends the field of rv, converted as the field's options
+// say.
+func (c *Context) appendField(b []byte, f *fieldInfo, rv reflect.Value) ([]byte, error) {
+	v := rv.Field(f.index)
+	if v.Kind() == reflect.String && f.format != StringDefault {
+		return c.appendStringAs(b, v.String(), f.format)
 	}
+	return c.appendValue(b, v)
+}
 
-	if err != nil {
+func (c *Context) appendRef(b []byte, ref Ref) (_ []byte, err error) {
+	tag := byte(ettNewerRef)
+	if c.legacyNodeTerms() {
+		tag = ettNewRef
+	}
+
+	// $ZLLNCCCCIIII…
+	n := len(ref.Id)
+	b = append(b, tag, byte(n>>8), byte(n))
+	if b, err = c.appendAtom(b, ref.Node); err != nil {
 		return
 	}
+	if b, err = appendCreation(b, ref.Creation, tag == ettNewRef); err != nil {
+		return
+	}
+	for _, v := range ref.Id {
+		b = be.AppendUint32(b, v)
+	}
 
+	return b, nil
+}
+
+func (c *Context) appendTuple(b []byte, tuple Tuple) (_ []byte, err error) {
+	b = appendTupleHead(b, len(tuple))
 	for _, v := range tuple {
-		if err = c.Write(w, v); err != nil {
+		if b, err = c.appendTerm(b, v); err != nil {
 			return
 		}
 	}
 
-	return
+	return b, nil
+}
+
+func appendTupleHead(b []byte, n int) []byte {
+	if n <= math.MaxUint8 {
+		// $hA
+		return append(b, ettSmallTuple, byte(n))
+	}
+	// $iAAAA
+	b = append(b, ettLargeTuple)
+	return be.AppendUint32(b, uint32(n))
 }
 
 func reverse(b []byte) []byte {
@@ -27,7 +27,7 @@ func BenchmarkWriteAtom(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		in := atoms[i%max]
-		if err := c.writeAtom(Discard, in); err != nil {
+		if err := c.Write(Discard, in); err != nil {
 			b.Fatal(in, err)
 		}
 	}
@@ -53,7 +53,7 @@ func BenchmarkWriteBigInt(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		in := bigints[i%max]
-		if err := c.writeBigInt(Discard, in); err != nil {
+		if err := c.Write(Discard, in); err != nil {
 			b.Fatal(in, err)
 		}
 	}
@@ -80,7 +80,7 @@ func BenchmarkWriteBinary(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		in := binaries[i%max]
-		if err := c.writeBinary(Discard, in); err != nil {
+		if err := c.Write(Discard, in); err != nil {
 			b.Fatal(in, err)
 		}
 	}
@@ -102,7 +102,7 @@ func BenchmarkWriteBool(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		in := bools[i%max]
-		if err := c.writeBool(Discard, in); err != nil {
+		if err := c.Write(Discard, in); err != nil {
 			b.Fatal(in, err)
 		}
 	}
@@ -124,7 +124,7 @@ func BenchmarkWriteFloat(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		in := floats[i%max]
-		if err := c.writeFloat(Discard, in); err != nil {
+		if err := c.Write(Discard, in); err != nil {
 			b.Fatal(in, err)
 		}
 	}
@@ -146,7 +146,7 @@ func BenchmarkWriteInt(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		in := ints[i%max]
-		if err := c.writeInt(Discard, in); err != nil {
+		if err := c.Write(Discard, in); err != nil {
 			b.Fatal(in, err)
 		}
 	}
@@ -168,7 +168,7 @@ func BenchmarkWriteUint(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		in := ints[i%max]
-		if err := c.writeUint(Discard, in); err != nil {
+		if err := c.Write(Discard, in); err != nil {
 			b.Fatal(in, err)
 		}
 	}
@@ -191,7 +191,7 @@ func BenchmarkWritePid(b *testing.B) {
 			Atom(b),
 			uint32(rand.Intn(65536)),
 			uint32(rand.Intn(256)),
-			byte(rand.Intn(16)),
+			uint32(rand.Intn(16)),
 		}
 	}
 
@@ -199,7 +199,7 @@ func BenchmarkWritePid(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		in := pids[i%max]
-		if err := c.writePid(Discard, in); err != nil {
+		if err := c.Write(Discard, in); err != nil {
 			b.Fatal(in, err)
 		}
 	}
@@ -223,8 +223,39 @@ func BenchmarkWriteString(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		in := strings[i%max]
-		if err := c.writeString(Discard, in); err != nil {
+		if err := c.Write(Discard, in); err != nil {
 			b.Fatal(in, err)
 		}
 	}
 }
+
+func BenchmarkWriteRecord(b *testing.B) {
+	type record struct {
+		_     struct{} `etf:"user"`
+		Name  string   `etf:"name,binary"`
+		Email string   `etf:"email,binary"`
+		Age   int
+		Score float64
+		Tags  []Atom
+	}
+	in := record{Name: "joe", Email: "joe@example.com", Age: 42, Score: 0.75, Tags: []Atom{"admin", "ops"}}
+	c := new(Context)
+
+	b.Run("Write", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := c.Write(Discard, in); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("AppendTerm", func(b *testing.B) {
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			var err error
+			if buf, err = c.AppendTerm(buf[:0], in); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
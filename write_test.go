@@ -2,17 +2,20 @@ package etf
 
 import (
 	"bytes"
+	"compress/zlib"
 	"math"
 	"math/big"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestWriteAtom(t *testing.T) {
 	c := new(Context)
 	test := func(in Atom, shouldFail bool) {
 		w := new(bytes.Buffer)
-		if err := c.writeAtom(w, in); err != nil {
+		if err := c.Write(w, in); err != nil {
 			if !shouldFail {
 				t.Error(in, err)
 			}
@@ -29,16 +32,44 @@ func TestWriteAtom(t *testing.T) {
 
 	test(Atom(""), false)
 	test(Atom(bytes.Repeat([]byte{'a'}, math.MaxUint8)), false)
-	test(Atom(bytes.Repeat([]byte{'a'}, math.MaxUint8+1)), false)
-	test(Atom(bytes.Repeat([]byte{'a'}, math.MaxUint16)), false)
-	test(Atom(bytes.Repeat([]byte{'a'}, math.MaxUint16+1)), true)
+	test(Atom(bytes.Repeat([]byte{'a'}, math.MaxUint8+1)), true)
+	test(Atom(strings.Repeat("é", math.MaxUint8)), false)
+	test(Atom(strings.Repeat("é", math.MaxUint8+1)), true)
+	test(Atom([]byte{'a', 233}), true)
+
+	encodings := []struct {
+		target int
+		in     Atom
+		exp    []byte
+	}{
+		{0, "ok", []byte{119, 2, 'o', 'k'}},
+		{0, "é", []byte{119, 2, 195, 169}},
+		{0, Atom(strings.Repeat("é", 128)), append([]byte{118, 1, 0}, strings.Repeat("é", 128)...)},
+		{25, "é", []byte{115, 1, 233}},
+		{25, Atom(strings.Repeat("é", 128)), append([]byte{115, 128}, bytes.Repeat([]byte{233}, 128)...)},
+		{25, "€", []byte{119, 3, 226, 130, 172}},
+		{26, "é", []byte{119, 2, 195, 169}},
+	}
+	for _, tc := range encodings {
+		c := &Context{TargetOTP: tc.target}
+		w := new(bytes.Buffer)
+		if err := c.Write(w, tc.in); err != nil {
+			t.Error(tc.in, err)
+		} else if !bytes.Equal(w.Bytes(), tc.exp) {
+			t.Errorf("%d %s: expected %v, got %v", tc.target, tc.in, tc.exp, w.Bytes())
+		} else if v, err := c.Read(w); err != nil {
+			t.Error(tc.in, err)
+		} else if v != tc.in {
+			t.Errorf("expected %v, got %v", tc.in, v)
+		}
+	}
 }
 
 func TestWriteBinary(t *testing.T) {
 	c := new(Context)
 	test := func(in []byte) {
 		w := new(bytes.Buffer)
-		if err := c.writeBinary(w, in); err != nil {
+		if err := c.Write(w, in); err != nil {
 			t.Error(in, err)
 		} else if v, err := c.Read(w); err != nil {
 			t.Error(in, err)
@@ -54,11 +85,41 @@ func TestWriteBinary(t *testing.T) {
 	test(bytes.Repeat([]byte{123}, 65536))
 }
 
+func TestWriteBitString(t *testing.T) {
+	c := new(Context)
+
+	// <<1:3>>, low bits are cleared
+	w := new(bytes.Buffer)
+	exp := []byte{77, 0, 0, 0, 1, 3, 32}
+	if err := c.Write(w, BitString{[]byte{63}, 3}); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(w.Bytes(), exp) {
+		t.Errorf("expected %v, got %v", exp, w.Bytes())
+	} else if v, err := c.Read(w); err != nil {
+		t.Error(err)
+	} else if exp := (BitString{[]byte{32}, 3}); !reflect.DeepEqual(v, exp) {
+		t.Errorf("expected %v, got %v", exp, v)
+	}
+
+	// <<>>
+	w.Reset()
+	exp = []byte{109, 0, 0, 0, 0}
+	if err := c.Write(w, BitString{}); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(w.Bytes(), exp) {
+		t.Errorf("expected %v, got %v", exp, w.Bytes())
+	}
+
+	if err := c.Write(w, BitString{[]byte{1}, 0}); err == nil {
+		t.Error("err == nil")
+	}
+}
+
 func TestWriteBool(t *testing.T) {
 	c := new(Context)
 	test := func(in bool) {
 		w := new(bytes.Buffer)
-		if err := c.writeBool(w, in); err != nil {
+		if err := c.Write(w, in); err != nil {
 			t.Error(in, err)
 		} else if v, err := c.Read(w); err != nil {
 			t.Error(in, err)
@@ -73,11 +134,42 @@ func TestWriteBool(t *testing.T) {
 	test(false)
 }
 
+func TestWriteCompressed(t *testing.T) {
+	test := func(c *Context, in Term, compressed bool) {
+		w := new(bytes.Buffer)
+		if err := c.Write(w, in); err != nil {
+			t.Error(in, err)
+		} else if tag := w.Bytes()[0]; (tag == ettCompressed) != compressed {
+			t.Errorf("%v: unexpected tag %d", in, tag)
+		} else if v, err := c.Read(w); err != nil {
+			t.Error(in, err)
+		} else if l := w.Len(); l != 0 {
+			t.Errorf("%v: buffer len %d", in, l)
+		} else if !reflect.DeepEqual(v, in) {
+			t.Errorf("expected %v, got %v", in, v)
+		}
+	}
+
+	long := string(bytes.Repeat([]byte("abc"), 1000))
+	test(&Context{Compression: zlib.BestCompression}, long, true)
+	test(&Context{Compression: zlib.DefaultCompression}, Tuple{long, long}, true)
+	test(&Context{Compression: zlib.BestSpeed, CompressThreshold: 4000}, long, false)
+	test(&Context{Compression: zlib.BestSpeed, CompressThreshold: 3000}, long, true)
+
+	// incompressible
+	test(&Context{Compression: zlib.BestCompression}, Atom("ok"), false)
+
+	c := &Context{Compression: 42}
+	if err := c.Write(new(bytes.Buffer), long); err == nil {
+		t.Error("err == nil")
+	}
+}
+
 func TestWriteFloat(t *testing.T) {
 	c := new(Context)
 	test := func(in float64) {
 		w := new(bytes.Buffer)
-		if err := c.writeFloat(w, in); err != nil {
+		if err := c.Write(w, in); err != nil {
 			t.Error(in, err)
 		} else if v, err := c.Read(w); err != nil {
 			t.Error(in, err)
@@ -98,7 +190,7 @@ func TestWriteInt(t *testing.T) {
 	c := new(Context)
 	test := func(in int64) {
 		w := new(bytes.Buffer)
-		if err := c.writeInt(w, in); err != nil {
+		if err := c.Write(w, in); err != nil {
 			t.Error(in, err)
 		} else if v, err := c.Read(w); err != nil {
 			t.Error(in, err)
@@ -125,7 +217,7 @@ func TestWriteUint(t *testing.T) {
 	c := new(Context)
 	test := func(in uint64) {
 		w := new(bytes.Buffer)
-		if err := c.writeUint(w, in); err != nil {
+		if err := c.Write(w, in); err != nil {
 			t.Error(in, err)
 		} else if v, err := c.Read(w); err != nil {
 			t.Error(in, err)
@@ -155,11 +247,174 @@ func TestWriteUint(t *testing.T) {
 	test(math.MaxUint64)
 }
 
+func TestWriteMap(t *testing.T) {
+	c := new(Context)
+	test := func(in []byte) {
+		r := bytes.NewBuffer(in)
+		w := new(bytes.Buffer)
+		if v, err := c.Read(r); err != nil {
+			t.Error(in, err)
+		} else if err := c.Write(w, v); err != nil {
+			t.Error(v, err)
+		} else if bytes.Compare(w.Bytes(), in) != 0 {
+			t.Errorf("expected %v, got %v", in, w.Bytes())
+		}
+	}
+
+	// #{}
+	test([]byte{116, 0, 0, 0, 0})
+	// #{b => 1, a => [x]} (order is preserved)
+	test([]byte{
+		116, 0, 0, 0, 2,
+		119, 1, 98, 97, 1,
+		119, 1, 97, 108, 0, 0, 0, 1, 119, 1, 120, 106,
+	})
+	// #{<<"k">> => #{{1} => 2}}
+	test([]byte{
+		116, 0, 0, 0, 1,
+		109, 0, 0, 0, 1, 107,
+		116, 0, 0, 0, 1, 104, 1, 97, 1, 97, 2,
+	})
+}
+
+func TestWriteFun(t *testing.T) {
+	c := new(Context)
+
+	f, err := c.Read(bytes.NewReader(testFun))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := new(bytes.Buffer)
+	if err = c.Write(w, f); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(w.Bytes(), testFun) {
+		t.Errorf("expected %v, got %v", testFun, w.Bytes())
+	}
+
+	// Size and Free are computed
+	fn := f.(Function)
+	fn.Size, fn.Free = 0, 0
+	w.Reset()
+	if err = c.Write(w, fn); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(w.Bytes(), testFun) {
+		t.Errorf("expected %v, got %v", testFun, w.Bytes())
+	}
+
+	// FUN_EXT, which has no Arity, Unique and Index, stays FUN_EXT
+	old := []byte{
+		117, 0, 0, 0, 1,
+		88, 119, 3, 'n', '@', 'h', 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0,
+		119, 1, 'm',
+		97, 5,
+		98, 7, 91, 205, 21,
+		97, 42,
+	}
+	if f, err = c.Read(bytes.NewReader(old)); err != nil {
+		t.Fatal(err)
+	}
+	w.Reset()
+	if err = c.Write(w, f); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(w.Bytes(), old) {
+		t.Errorf("expected %v, got %v", old, w.Bytes())
+	} else if n, err := c.EncodedSize(f); err != nil || n != len(old) {
+		t.Errorf("EncodedSize: expected %d, got %d (%v)", len(old), n, err)
+	}
+
+	w.Reset()
+	exp := []byte{113, 119, 1, 'm', 119, 1, 'f', 97, 2}
+	if err = c.Write(w, Export{"m", "f", 2}); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(w.Bytes(), exp) {
+		t.Errorf("expected %v, got %v", exp, w.Bytes())
+	}
+}
+
+func TestWriteImproperList(t *testing.T) {
+	c := new(Context)
+
+	// [<<"a">> | <<"b">>], an iolist
+	exp := []byte{108, 0, 0, 0, 1, 109, 0, 0, 0, 1, 97, 109, 0, 0, 0, 1, 98}
+	w := new(bytes.Buffer)
+	in := ImproperList{List{[]byte("a")}, []byte("b")}
+	if err := c.Write(w, in); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(w.Bytes(), exp) {
+		t.Errorf("expected %v, got %v", exp, w.Bytes())
+	} else if v, err := c.Read(w); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(v, in) {
+		t.Errorf("expected %#v, got %#v", in, v)
+	}
+
+	// no elements, just the tail
+	w.Reset()
+	exp = []byte{97, 1}
+	if err := c.Write(w, ImproperList{Tail: 1}); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(w.Bytes(), exp) {
+		t.Errorf("expected %v, got %v", exp, w.Bytes())
+	}
+}
+
+func TestWriteGoMap(t *testing.T) {
+	test := func(keys StringFormat, in interface{}, exp []byte) {
+		c := &Context{MapKeys: keys}
+		w := new(bytes.Buffer)
+		if err := c.Write(w, in); err != nil {
+			t.Error(in, err)
+		} else if bytes.Compare(w.Bytes(), exp) != 0 {
+			t.Errorf("expected %v, got %v", exp, w.Bytes())
+		}
+	}
+
+	in := map[string]interface{}{"b": 1, "a": "x"}
+	test(StringDefault, in, []byte{
+		116, 0, 0, 0, 2,
+		107, 0, 1, 97, 107, 0, 1, 120,
+		107, 0, 1, 98, 97, 1,
+	})
+	test(StringBinary, in, []byte{
+		116, 0, 0, 0, 2,
+		109, 0, 0, 0, 1, 97, 107, 0, 1, 120,
+		109, 0, 0, 0, 1, 98, 97, 1,
+	})
+	test(StringAtom, in, []byte{
+		116, 0, 0, 0, 2,
+		119, 1, 97, 107, 0, 1, 120,
+		119, 1, 98, 97, 1,
+	})
+
+	// atoms are left alone, ints sort before atoms
+	test(StringBinary, map[interface{}]Term{Atom("ok"): 1, 300: 2, 2: 3}, []byte{
+		116, 0, 0, 0, 3,
+		97, 2, 97, 3,
+		98, 0, 0, 1, 44, 97, 2,
+		119, 2, 111, 107, 97, 1,
+	})
+
+	test(StringDefault, map[Atom]int{}, []byte{116, 0, 0, 0, 0})
+
+	// by default, keys follow Strings
+	c := &Context{Strings: StringBinary}
+	w := new(bytes.Buffer)
+	exp := []byte{
+		116, 0, 0, 0, 1,
+		109, 0, 0, 0, 1, 97, 109, 0, 0, 0, 1, 120,
+	}
+	if err := c.Write(w, map[string]string{"a": "x"}); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(w.Bytes(), exp) {
+		t.Errorf("expected %v, got %v", exp, w.Bytes())
+	}
+}
+
 func TestWritePid(t *testing.T) {
 	c := new(Context)
 	test := func(in Pid) {
 		w := new(bytes.Buffer)
-		if err := c.writePid(w, in); err != nil {
+		if err := c.Write(w, in); err != nil {
 			t.Error(in, err)
 		} else if v, err := c.Read(w); err != nil {
 			t.Error(in, err)
@@ -172,13 +427,67 @@ func TestWritePid(t *testing.T) {
 
 	test(Pid{Atom("omg@lol"), 38, 0, 3})
 	test(Pid{Atom("self@localhost"), 32, 1, 9})
+	test(Pid{Atom("self@localhost"), 1 << 20, 1 << 30, 1 << 31})
+
+	c.TargetOTP = 18
+	test(Pid{Atom("omg@lol"), 38, 0, 3})
+}
+
+func TestWriteNodeTerms(t *testing.T) {
+	test := func(otp int, in Term, exp []byte, shouldFail bool) {
+		c := &Context{TargetOTP: otp}
+		w := new(bytes.Buffer)
+		if err := c.Write(w, in); err != nil {
+			if !shouldFail {
+				t.Error(in, err)
+			}
+		} else if shouldFail {
+			t.Errorf("err == nil (%v)", in)
+		} else if bytes.Compare(w.Bytes(), exp) != 0 {
+			t.Errorf("%d: expected %v, got %v", otp, exp, w.Bytes())
+		}
+	}
+
+	pid := Pid{Atom("a"), 1, 2, 3}
+	test(0, pid, []byte{88, 119, 1, 97, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3}, false)
+	test(18, pid, []byte{103, 115, 1, 97, 0, 0, 0, 1, 0, 0, 0, 2, 3}, false)
+
+	port := Port{Atom("a"), 7, 3}
+	test(0, port, []byte{89, 119, 1, 97, 0, 0, 0, 7, 0, 0, 0, 3}, false)
+	test(18, port, []byte{102, 115, 1, 97, 0, 0, 0, 7, 3}, false)
+
+	port.Id = 1<<32 | 7
+	test(0, port, []byte{120, 119, 1, 97, 0, 0, 0, 1, 0, 0, 0, 7, 0, 0, 0, 3}, false)
+	test(24, port, []byte{120, 115, 1, 97, 0, 0, 0, 1, 0, 0, 0, 7, 0, 0, 0, 3}, false)
+	test(23, port, nil, true)
+
+	ref := Ref{Atom("a"), 3, []uint32{1, 2}}
+	test(0, ref, []byte{90, 0, 2, 119, 1, 97, 0, 0, 0, 3, 0, 0, 0, 1, 0, 0, 0, 2}, false)
+	test(18, ref, []byte{114, 0, 2, 115, 1, 97, 3, 0, 0, 0, 1, 0, 0, 0, 2}, false)
+
+	// the legacy encodings have a single byte of creation
+	pid.Creation, port.Creation, ref.Creation = 255, 255, 255
+	port.Id = 7
+	test(18, pid, []byte{103, 115, 1, 97, 0, 0, 0, 1, 0, 0, 0, 2, 255}, false)
+	test(18, port, []byte{102, 115, 1, 97, 0, 0, 0, 7, 255}, false)
+	test(18, ref, []byte{114, 0, 2, 115, 1, 97, 255, 0, 0, 0, 1, 0, 0, 0, 2}, false)
+
+	pid.Creation, port.Creation, ref.Creation = 256, 256, 256
+	c := &Context{TargetOTP: 18}
+	for _, in := range []Term{pid, port, ref, Tuple{pid}} {
+		test(18, in, nil, true)
+		if _, err := c.EncodedSize(in); err == nil {
+			t.Errorf("EncodedSize(%v): err == nil", in)
+		}
+	}
+	test(19, pid, []byte{88, 115, 1, 97, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 1, 0}, false)
 }
 
 func TestWriteString(t *testing.T) {
 	c := new(Context)
 	test := func(in string, shouldFail bool) {
 		w := new(bytes.Buffer)
-		if err := c.writeString(w, in); err != nil {
+		if err := c.Write(w, in); err != nil {
 			if !shouldFail {
 				t.Error(in, err)
 			}
@@ -196,6 +505,38 @@ func TestWriteString(t *testing.T) {
 	test(string(bytes.Repeat([]byte{'a'}, math.MaxUint16)), false)
 	test("", false)
 	test(string(bytes.Repeat([]byte{'a'}, math.MaxUint16+1)), true)
+
+	formats := []struct {
+		format StringFormat
+		in     string
+		exp    []byte
+	}{
+		{StringCharlist, "é", []byte{107, 0, 1, 233}},
+		{StringCharlist, "é€", []byte{108, 0, 0, 0, 2, 97, 233, 98, 0, 0, 32, 172, 106}},
+		{StringCharlist, "", []byte{106}},
+		{StringBinary, "é", []byte{109, 0, 0, 0, 2, 195, 169}},
+		{StringAtom, "ok", []byte{119, 2, 111, 107}},
+		{StringList, "ab", []byte{108, 0, 0, 0, 2, 97, 97, 97, 98, 106}},
+		{StringList, "", []byte{106}},
+	}
+	for _, tc := range formats {
+		c := &Context{Strings: tc.format}
+		w := new(bytes.Buffer)
+		if err := c.Write(w, tc.in); err != nil {
+			t.Error(tc.in, err)
+		} else if !bytes.Equal(w.Bytes(), tc.exp) {
+			t.Errorf("%d %q: expected %v, got %v", tc.format, tc.in, tc.exp, w.Bytes())
+		}
+	}
+
+	// long charlists don't fit STRING_EXT
+	w := new(bytes.Buffer)
+	c = &Context{Strings: StringCharlist}
+	if err := c.Write(w, strings.Repeat("a", math.MaxUint16+1)); err != nil {
+		t.Error(err)
+	} else if w.Bytes()[0] != ettList {
+		t.Errorf("expected LIST_EXT, got %d", w.Bytes()[0])
+	}
 }
 
 func TestWriteTerm(t *testing.T) {
@@ -243,3 +584,262 @@ func TestWriteTerm(t *testing.T) {
 		}
 	}
 }
+
+func TestWriteDist(t *testing.T) {
+	c := new(Context)
+	peer := new(Context)
+	ctrl := Tuple{2, Atom(""), Pid{Atom("a@localhost"), 1, 0, 0}}
+	msg := Tuple{Atom("hello"), true, List{Atom("hello"), Atom("world")}}
+
+	test := func(newEntries int) {
+		w := new(bytes.Buffer)
+		if err := c.WriteDist(w, []Term{ctrl, msg}); err != nil {
+			t.Fatal(err)
+		} else if err := c.Write(w, ctrl); err != nil {
+			t.Fatal(err)
+		} else if err := c.Write(w, msg); err != nil {
+			t.Fatal(err)
+		}
+
+		// 'D', 5 refs, 3 bytes of flags
+		b := w.Bytes()
+		if b[0] != EtDist || b[1] != 5 {
+			t.Fatalf("bad header %v", b[:2])
+		}
+		n := 0
+		for i := 0; i < 5; i++ {
+			if b[2+i/2]>>(4*uint(i&1))&0x08 != 0 {
+				n++
+			}
+		}
+		if n != newEntries {
+			t.Errorf("expected %d new entries, got %d", newEntries, n)
+		}
+
+		if err := peer.ReadDist(w); err != nil {
+			t.Fatal(err)
+		} else if v, err := peer.Read(w); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(v, ctrl) {
+			t.Errorf("expected %v, got %v", ctrl, v)
+		} else if v, err := peer.Read(w); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(v, msg) {
+			t.Errorf("expected %v, got %v", msg, v)
+		} else if l := w.Len(); l != 0 {
+			t.Errorf("buffer len %d", l)
+		}
+	}
+
+	test(5)
+	test(0)
+
+	// no atoms
+	w := new(bytes.Buffer)
+	if err := c.WriteDist(w, []Term{Tuple{1}}); err != nil {
+		t.Fatal(err)
+	} else if exp := []byte{EtDist, 0}; bytes.Compare(w.Bytes(), exp) != 0 {
+		t.Errorf("expected %v, got %v", exp, w.Bytes())
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	test := func(in Term, exp []byte) {
+		if b, err := Marshal(in); err != nil {
+			t.Error(in, err)
+		} else if bytes.Compare(b, exp) != 0 {
+			t.Errorf("expected %v, got %v", exp, b)
+		} else {
+			var v Term
+			if err := Unmarshal(b, &v); err != nil {
+				t.Error(in, err)
+			}
+		}
+	}
+
+	test(1, []byte{131, 97, 1})
+	test(Tuple{Atom("ok"), "abc"}, []byte{131, 104, 2, 119, 2, 111, 107, 107, 0, 3, 97, 98, 99})
+	test([]int{}, []byte{131, 106})
+
+	if _, err := Marshal(make(chan int)); err == nil {
+		t.Error("err == nil")
+	}
+}
+
+func TestWriteMarshaler(t *testing.T) {
+	in := Tuple{
+		testUUID{15: 1},
+		&testUUID{0: 1},
+		testTime{time.Unix(1500000000, 5000)},
+	}
+	exp := []byte{
+		131, 104, 3,
+		109, 0, 0, 0, 16, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+		109, 0, 0, 0, 16, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		104, 3, 98, 0, 0, 0x05, 0xdc, 97, 0, 97, 5,
+	}
+	if b, err := Marshal(in); err != nil {
+		t.Error(err)
+	} else if bytes.Compare(b, exp) != 0 {
+		t.Errorf("expected %v, got %v", exp, b)
+	}
+}
+
+func TestAppendTerm(t *testing.T) {
+	type record struct {
+		_    struct{} `etf:"rec"`
+		Name string   `etf:"name,binary"`
+		N    int
+		F    float32
+		L    []uint16
+	}
+	type asMap struct {
+		_ struct{} `etf:",map"`
+		Z bool     `etf:"z"`
+		A string   `etf:"a,atom"`
+		M string   `etf:"m"`
+	}
+	big1 := new(big.Int).Lsh(big.NewInt(1), 100)
+	n := 1 << 40
+	bigTuple := make(Tuple, 300)
+	for i := range bigTuple {
+		bigTuple[i] = i
+	}
+	terms := []Term{
+		0, -1, 255, 256, math.MaxInt32 + 1, math.MinInt64, uint64(math.MaxUint64),
+		big1, new(big.Int).Neg(big1), new(big.Int), 1.5, float32(-2),
+		true, Atom("ok"), Atom("été"), "abc", "", []byte("xyz"), BitString{[]byte{0xff}, 3},
+		Charlist("abc"), Charlist("€"), List{}, List{1, Atom("a"), List{"b"}}, []int{1, 2},
+		[2]string{"a", "b"}, Tuple{}, Tuple{Atom("x"), 1}, bigTuple,
+		Map{{Atom("a"), 1}}, map[string]int{"b": 2, "a": 1},
+		ImproperList{List{1}, 2}, ImproperList{nil, Atom("t")},
+		Pid{Atom("a@b"), 1, 2, 3}, Port{Atom("a@b"), 1 << 40, 3}, Port{Atom("a@b"), 5, 3},
+		Ref{Atom("a@b"), 3, []uint32{1, 2, 3}},
+		Export{"lists", "map", 2},
+		Function{Arity: 1, Module: "m", Pid: Pid{Node: "a@b"}, FreeVars: []Term{1, "x"}},
+		record{Name: "n", N: 1 << 20, F: 0.5, L: []uint16{1, 1000}},
+		&record{}, asMap{Z: true, A: "a", M: "m"}, &n,
+		testUUID{1}, testTime{time.Unix(1, 0)},
+	}
+	contexts := []*Context{
+		{},
+		{TargetOTP: 18},
+		{Strings: StringBinary, MapKeys: StringAtom},
+		{Strings: StringCharlist},
+	}
+
+	for _, c := range contexts {
+		for _, term := range terms {
+			w := new(bytes.Buffer)
+			if err := c.Write(w, term); err != nil {
+				// only OTP 24 can take the biggest port ids
+				if c.TargetOTP == 0 {
+					t.Errorf("%v: %v", term, err)
+				} else if _, err := c.EncodedSize(term); err == nil {
+					t.Errorf("%v: EncodedSize: err == nil", term)
+				}
+				continue
+			}
+
+			size, err := c.EncodedSize(term)
+			if err != nil {
+				t.Errorf("%v: %v", term, err)
+			} else if size != w.Len() {
+				t.Errorf("%v: expected size %d, got %d", term, w.Len(), size)
+			}
+
+			prefix := []byte{EtVersion}
+			b, err := c.AppendTerm(prefix, term)
+			if err != nil {
+				t.Errorf("%v: %v", term, err)
+			} else if b[0] != EtVersion || !bytes.Equal(b[1:], w.Bytes()) {
+				t.Errorf("%v: expected %v, got %v", term, w.Bytes(), b[1:])
+			}
+
+			if _, err := c.Read(w); err != nil {
+				t.Errorf("%v: %v", term, err)
+			}
+		}
+	}
+
+	bad := []Term{
+		nil, make(chan int), Atom([]byte{0xff}), BitString{[]byte{1}, 9},
+		Tuple{1, complex(1, 2)}, []Term{strings.Repeat("a", math.MaxUint16+1)},
+		Port{Id: 1 << 40},
+	}
+	c := &Context{TargetOTP: 23}
+	for _, term := range bad {
+		dst := []byte{1, 2, 3}
+		if b, err := c.AppendTerm(dst, term); err == nil {
+			t.Errorf("%v: err == nil", term)
+		} else if !bytes.Equal(b, dst) {
+			t.Errorf("%v: expected %v, got %v", term, dst, b)
+		}
+		if _, err := c.EncodedSize(term); err == nil {
+			t.Errorf("%v: EncodedSize: err == nil", term)
+		}
+	}
+}
+
+func TestAppendTermCompressed(t *testing.T) {
+	c := &Context{Compression: zlib.BestCompression}
+	in := List{strings.Repeat("a", 1000), strings.Repeat("b", 1000)}
+	size, err := c.EncodedSize(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := c.AppendTerm([]byte{EtVersion}, in)
+	if err != nil {
+		t.Fatal(err)
+	} else if b[1] != ettCompressed {
+		t.Errorf("expected COMPRESSED_EXT, got %d", b[1])
+	} else if len(b)-1 >= size {
+		t.Errorf("expected less than %d bytes, got %d", size, len(b)-1)
+	}
+
+	var v Term
+	if err := Unmarshal(b, &v); err != nil {
+		t.Error(err)
+	} else if !Equal(v, in) {
+		t.Errorf("expected %v, got %v", in, v)
+	}
+}
+
+func TestAppendTermAllocs(t *testing.T) {
+	type record struct {
+		_     struct{} `etf:"rec"`
+		Name  string
+		Kind  string `etf:"kind,atom"`
+		Data  string `etf:"data,binary"`
+		N     int64
+		F     float64
+		L     []int
+		Flags []Atom
+	}
+	terms := []Term{
+		Tuple{Atom("ok"), 1, -1 << 40, 1.5, "abc", []byte("xyz"), List{Atom("a"), true}},
+		Pid{Atom("a@b"), 1, 2, 3},
+		new(big.Int).Lsh(big.NewInt(1), 100),
+		record{Name: "n", Kind: "k", Data: "d", N: 1 << 40, F: 0.5, L: []int{1, 1000}, Flags: []Atom{"x"}},
+	}
+
+	c := new(Context)
+	for _, term := range terms {
+		size, err := c.EncodedSize(term)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 0, size)
+		allocs := testing.AllocsPerRun(100, func() {
+			b, err = c.AppendTerm(b[:0], term)
+		})
+		if err != nil {
+			t.Error(err)
+		} else if allocs != 0 {
+			t.Errorf("%v: expected no allocations, got %v", term, allocs)
+		} else if len(b) != size || cap(b) != size {
+			t.Errorf("%v: expected %d bytes, got %d (cap %d)", term, size, len(b), cap(b))
+		}
+	}
+}